@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"lisp/lox"
+)
+
+// astCommand prints the syntax tree of a script, or of stdin when no script
+// is given, as S-expressions or as JSON.
+func astCommand(args []string) int {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	format := flags.String("format", "sexpr", "output format: sexpr or json")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lox ast [-format=sexpr|json] [script.lox]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 1 || (*format != "sexpr" && *format != "json") {
+		flags.Usage()
+		return exitUsage
+	}
+
+	path := "<stdin>"
+	var source []byte
+	var err error
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+		source, err = ioutil.ReadFile(path)
+	} else {
+		source, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+
+	scanner := lox.NewScanner()
+	if flags.NArg() == 1 {
+		scanner.File = path
+	}
+	if err := scanner.Eval(string(source)); err != nil {
+		reportErrors(path, string(source), err)
+		return exitScan
+	}
+	statements, err := lox.NewParser(scanner.Tokens).Parse()
+	if err != nil {
+		reportErrors(path, string(source), err)
+		return exitParse
+	}
+
+	if *format == "sexpr" {
+		fmt.Print(lox.NewAstPrinter().Print(statements))
+		return exitOK
+	}
+	data, err := lox.MarshalAST(statements)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	fmt.Println(string(data))
+	return exitOK
+}
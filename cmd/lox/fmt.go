@@ -0,0 +1,201 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"lisp/lox"
+)
+
+// fmtCommand prints each script in canonical style, or rewrites it in place
+// with -w, or prints a diff against the canonical style with -d. Without
+// files it formats stdin to stdout.
+func fmtCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source file instead of stdout")
+	diff := flags.Bool("d", false, "print a diff instead of the formatted source")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lox fmt [-w | -d] [script.lox...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *write && *diff {
+		flags.Usage()
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "lox fmt: cannot use -w with stdin")
+			return exitUsage
+		}
+		source, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
+		}
+		return formatSource("<stdin>", string(source), *diff)
+	}
+
+	status := exitOK
+	for _, path := range flags.Args() {
+		if code := formatFile(path, *write, *diff); code > status {
+			status = code
+		}
+	}
+	return status
+}
+
+func formatFile(path string, write bool, diff bool) int {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	if !write {
+		return formatSource(path, string(source), diff)
+	}
+
+	formatted, err := lox.Format(string(source))
+	if err != nil {
+		reportErrors(path, string(source), err)
+		return exitCode(err)
+	}
+	if formatted == string(source) {
+		return exitOK
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	if err := ioutil.WriteFile(path, []byte(formatted), info.Mode()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	return exitOK
+}
+
+func formatSource(path string, source string, diff bool) int {
+	formatted, err := lox.Format(source)
+	if err != nil {
+		reportErrors(path, source, err)
+		return exitCode(err)
+	}
+	if diff {
+		fmt.Print(unifiedDiff(path, source, formatted))
+	} else {
+		fmt.Print(formatted)
+	}
+	return exitOK
+}
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// unifiedDiff returns the changes turning before into after in unified
+// format, or "" when they are equal. The scripts are small, so it compares
+// lines with a plain longest-common-subsequence table.
+func unifiedDiff(path string, before string, after string) string {
+	if before == after {
+		return ""
+	}
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte
+		text string
+		i, j int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", path, path)
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+		// Grow the hunk while the next change is close enough to share
+		// context with this one.
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for k := start; k < len(edits); k++ {
+			if edits[k].op != ' ' {
+				end = k
+			} else if k-end > 2*diffContext {
+				break
+			}
+		}
+		last := end + diffContext
+		if last >= len(edits) {
+			last = len(edits) - 1
+		}
+
+		var removed, added int
+		for _, e := range edits[first : last+1] {
+			if e.op != '+' {
+				removed++
+			}
+			if e.op != '-' {
+				added++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", edits[first].i+1, removed, edits[first].j+1, added)
+		for _, e := range edits[first : last+1] {
+			out.WriteByte(e.op)
+			out.WriteString(e.text)
+			out.WriteByte('\n')
+		}
+		start = last + 1
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
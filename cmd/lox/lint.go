@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"lisp/lox"
+)
+
+// lintCommand reports static warnings for each script, one per line in the
+// form path:line:column: message (check). Scripts that fail to scan, parse
+// or resolve have their errors reported instead.
+func lintCommand(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lox lint script.lox...")
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	status := exitOK
+	for _, path := range flags.Args() {
+		if code := lintFile(path); code > status {
+			status = code
+		}
+	}
+	return status
+}
+
+func lintFile(path string) int {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	scanner := lox.NewScanner()
+	scanner.File = path
+	if err := scanner.Eval(string(source)); err != nil {
+		reportErrors(path, string(source), err)
+		return exitScan
+	}
+	statements, err := lox.NewParser(scanner.Tokens).Parse()
+	if err != nil {
+		reportErrors(path, string(source), err)
+		return exitParse
+	}
+
+	linter := lox.NewLinter()
+	err = linter.Resolve(statements)
+	warnings := linter.Warnings()
+	for _, w := range warnings {
+		fmt.Printf("%s:%d:%d: %s (%s)\n", path, w.Line, w.Column, w.Message, w.Check)
+	}
+	if err != nil {
+		reportErrors(path, string(source), err)
+		return exitResolve
+	}
+	if len(warnings) > 0 {
+		return exitLint
+	}
+	return exitOK
+}
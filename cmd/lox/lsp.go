@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"lisp/lox/lsp"
+)
+
+// lspCommand runs a language server for editors on stdin and stdout.
+func lspCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: lox lsp")
+		return exitUsage
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	return exitOK
+}
@@ -0,0 +1,201 @@
+// Command lox runs a Lox script, or starts an interactive session when no
+// script is given.
+//
+//	lox [-backend=tree|vm] [-path=dirs] [-max-depth=n] [script.lox]
+//	lox lint script.lox...
+//	lox fmt [-w | -d] [script.lox...]
+//	lox lsp
+//	lox ast [-format=sexpr|json] [script.lox]
+//
+// The exit status tells which stage rejected the program: 64 for bad usage,
+// 65 for scan errors, 66 for parse errors, 67 for resolve and compile errors,
+// 70 for runtime errors and 74 when the script cannot be read. lox lint
+// exits with 1 when it only found warnings. lox fmt rewrites scripts in
+// canonical style, lox lsp serves the Language Server Protocol on stdin
+// and stdout, and lox ast prints the syntax tree.
+//
+// Modules a script imports are looked up relative to the script, then in
+// the directories of -path, which defaults to the LOXPATH environment
+// variable.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"lisp/lox"
+)
+
+const (
+	exitOK      = 0
+	exitLint    = 1
+	exitUsage   = 64
+	exitScan    = 65
+	exitParse   = 66
+	exitResolve = 67
+	exitRuntime = 70
+	exitIO      = 74
+)
+
+// commands maps subcommand names to their entry points, which take the
+// remaining arguments and return the exit status.
+var commands = map[string]func(args []string) int{
+	"ast":  astCommand,
+	"fmt":  fmtCommand,
+	"lint": lintCommand,
+	"lsp":  lspCommand,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	backendName := flag.String("backend", "tree", "execution backend: tree or vm")
+	searchPath := flag.String("path", os.Getenv("LOXPATH"),
+		"directories to search for imported modules, separated by "+string(os.PathListSeparator))
+	maxDepth := flag.Int("max-depth", 0, "maximum call depth before a stack overflow (0 for the backend's default)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lox [-backend=tree|vm] [-path=dirs] [-max-depth=n] [script.lox]")
+		fmt.Fprintln(os.Stderr, "       lox lint script.lox...")
+		fmt.Fprintln(os.Stderr, "       lox fmt [-w | -d] [script.lox...]")
+		fmt.Fprintln(os.Stderr, "       lox lsp")
+		fmt.Fprintln(os.Stderr, "       lox ast [-format=sexpr|json] [script.lox]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	backend, ok := newBackend(*backendName)
+	if !ok || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if *searchPath != "" {
+		backend.SetSearchPath(filepath.SplitList(*searchPath)...)
+	}
+	backend.SetMaxCallDepth(*maxDepth)
+
+	if flag.NArg() == 0 {
+		repl(backend, os.Stdin)
+		return
+	}
+	os.Exit(runFile(backend, flag.Arg(0)))
+}
+
+func newBackend(name string) (lox.Backend, bool) {
+	switch name {
+	case "tree":
+		return lox.NewBackendWithOutput(lox.TreeWalker, os.Stdout, os.Stderr), true
+	case "vm":
+		return lox.NewBackendWithOutput(lox.Bytecode, os.Stdout, os.Stderr), true
+	}
+	return nil, false
+}
+
+func runFile(backend lox.Backend, path string) int {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	if err := run(backend, path, string(source)); err != nil {
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// run scans, parses, checks and executes source read from file, reporting
+// every error on stderr. Static errors are reported with the offending
+// source; the backend reports runtime errors to the diagnostics writer it
+// was created with.
+func run(backend lox.Backend, file string, source string) error {
+	scanner := lox.NewScanner()
+	scanner.File = file
+	if err := scanner.Eval(source); err != nil {
+		reportErrors("", source, err)
+		return err
+	}
+	statements, err := lox.NewParser(scanner.Tokens).Parse()
+	if err != nil {
+		reportErrors("", source, err)
+		return err
+	}
+	if err := check(backend, statements); err != nil {
+		reportErrors("", source, err)
+		return err
+	}
+	return backend.Interpret(statements)
+}
+
+// check finds the resolve errors, and on the VM the compile errors, that
+// Interpret would stop at before running anything.
+func check(backend lox.Backend, statements []lox.Stmt) error {
+	if err := lox.NewResolver(nil).Resolve(statements); err != nil {
+		return err
+	}
+	if _, ok := backend.(*lox.VM); ok {
+		if _, err := lox.NewCompiler().Compile(statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportErrors prints each error of err on stderr, prefixed with path when
+// it is not empty, and followed by the offending source line when the error
+// knows where it occurred.
+func reportErrors(path string, source string, err error) {
+	list, ok := err.(lox.ErrorList)
+	if !ok {
+		list = lox.ErrorList{err}
+	}
+	for _, err := range list {
+		if path != "" {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if span, ok := errorSpan(err); ok {
+			fmt.Fprintln(os.Stderr, lox.Snippet(source, span))
+		}
+	}
+}
+
+func errorSpan(err error) (lox.Span, bool) {
+	switch err := err.(type) {
+	case *lox.ScanError:
+		start := lox.Position{Line: err.Line, Column: err.Column}
+		return lox.Span{Start: start, End: start}, true
+	case *lox.ParseError:
+		return err.Token.Span(), true
+	case *lox.ResolveError:
+		return err.Token.Span(), true
+	case *lox.CompileError:
+		return err.Token.Span(), true
+	}
+	return lox.Span{}, false
+}
+
+// exitCode maps an error to the status of the stage that produced it. An
+// ErrorList only ever holds errors of a single stage.
+func exitCode(err error) int {
+	if list, ok := err.(lox.ErrorList); ok && len(list) > 0 {
+		err = list[0]
+	}
+	switch err.(type) {
+	case *lox.ScanError:
+		return exitScan
+	case *lox.ParseError:
+		return exitParse
+	case *lox.ResolveError, *lox.CompileError:
+		return exitResolve
+	case *lox.RuntimeError, *lox.LimitError:
+		return exitRuntime
+	}
+	return exitIO
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"lisp/lox"
+)
+
+const (
+	prompt         = "> "
+	continuePrompt = "... "
+)
+
+// repl reads statements from in and runs them against one backend, so
+// variables and functions defined on earlier lines stay visible. Input is
+// buffered until every brace, bracket and parenthesis is closed, letting
+// functions, classes and literals span several lines.
+func repl(backend lox.Backend, in io.Reader) {
+	reader := bufio.NewReader(in)
+	var buffer strings.Builder
+
+	fmt.Print(prompt)
+	for {
+		line, err := reader.ReadString('\n')
+		buffer.WriteString(line)
+
+		if err == nil && !complete(buffer.String()) {
+			fmt.Print(continuePrompt)
+			continue
+		}
+
+		if source := strings.TrimSpace(buffer.String()); source != "" {
+			run(backend, "", source)
+		}
+		buffer.Reset()
+
+		if err != nil {
+			return
+		}
+		fmt.Print(prompt)
+	}
+}
+
+// complete reports whether source has no unclosed braces, brackets,
+// parentheses or strings. Brackets inside strings and comments do not count since the
+// Scanner has already folded them into tokens.
+func complete(source string) bool {
+	scanner := lox.NewScanner()
+	if err := scanner.Eval(source); err != nil {
+		for _, e := range err.(lox.ErrorList) {
+			if e.(*lox.ScanError).Message == "Unterminated string." {
+				return false
+			}
+		}
+	}
+
+	depth := 0
+	for _, token := range scanner.Tokens {
+		switch token.TokenType {
+		case lox.LeftBrace, lox.LeftBracket, lox.LeftParen:
+			depth++
+		case lox.RightBrace, lox.RightBracket, lox.RightParen:
+			depth--
+		}
+	}
+	return depth <= 0
+}
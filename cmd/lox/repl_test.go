@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"lisp/lox"
+)
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"print 1;", true},
+		{"fun f() {", false},
+		{"print (1 +", false},
+		{"var xs = [1,", false},
+		{"var m = {1: [2,", false},
+		{`print "a`, false},
+		{`print "[";`, true},
+		{"// [\nprint 1;", true},
+	}
+	for _, test := range tests {
+		if got := complete(test.source); got != test.want {
+			t.Errorf("complete(%q) = %v, want %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestRepl(t *testing.T) {
+	input := "var xs = [1,\n2];\nprint xs;\nfun f() {\n  return len(xs);\n}\nprint f();\n"
+	for _, kind := range []lox.BackendKind{lox.TreeWalker, lox.Bytecode} {
+		var out bytes.Buffer
+		repl(lox.NewBackendWithOutput(kind, &out, ioutil.Discard), strings.NewReader(input))
+		if got := out.String(); got != "[1, 2]\n2\n" {
+			t.Errorf("backend %d: unexpected output %q", kind, got)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package lox
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MarshalAST returns the JSON form of statements: an array with one object
+// per statement. Every node is an object whose "kind" names its Go type,
+// followed by its fields in declaration order and its "span":
+//
+//	{"kind": "PrintStmt", "expression": {"kind": "LiteralExpr", "value": 1, "span": ...}, "span": ...}
+//
+// Tokens such as names and operators are written as their lexemes, missing
+// optional children as null. The key order is fixed so the output can be
+// diffed and used as a golden file.
+func MarshalAST(statements []Stmt) ([]byte, error) {
+	return json.MarshalIndent(astJSON{}.statements(statements), "", "  ")
+}
+
+// astJSON converts AST nodes to jsonObjects.
+type astJSON struct{}
+
+// jsonObject is a JSON object that keeps its keys in insertion order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for idx, field := range o {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// node builds the object for a node of the given kind with fields in the
+// order given, as key, value pairs.
+func (a astJSON) node(kind string, span Span, fields ...interface{}) jsonObject {
+	object := jsonObject{{"kind", kind}}
+	for idx := 0; idx+1 < len(fields); idx += 2 {
+		object = append(object, jsonField{fields[idx].(string), fields[idx+1]})
+	}
+	return append(object, jsonField{"span", a.span(span)})
+}
+
+func (a astJSON) span(span Span) jsonObject {
+	position := func(p Position) jsonObject {
+		return jsonObject{{"line", p.Line}, {"column", p.Column}, {"offset", p.Offset}}
+	}
+	object := jsonObject{}
+	if span.File != "" {
+		object = append(object, jsonField{"file", span.File})
+	}
+	return append(object, jsonField{"start", position(span.Start)}, jsonField{"end", position(span.End)})
+}
+
+func (a astJSON) expr(e Expr) interface{} {
+	if e == nil {
+		return nil
+	}
+	return e.Accept(a)
+}
+
+func (a astJSON) stmt(s Stmt) interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.Accept(a)
+}
+
+func (a astJSON) exprs(exprs []Expr) []interface{} {
+	out := make([]interface{}, len(exprs))
+	for idx, e := range exprs {
+		out[idx] = a.expr(e)
+	}
+	return out
+}
+
+func (a astJSON) statements(statements []Stmt) []interface{} {
+	out := make([]interface{}, len(statements))
+	for idx, s := range statements {
+		out[idx] = a.stmt(s)
+	}
+	return out
+}
+
+func (a astJSON) VisitGroupExpr(e *GroupExpr) interface{} {
+	return a.node("GroupExpr", e.Span(), "expression", a.expr(e.expression))
+}
+
+func (a astJSON) VisitBinaryExpr(e *BinaryExpr) interface{} {
+	return a.node("BinaryExpr", e.Span(), "left", a.expr(e.left), "operator", e.operator.Lexeme, "right", a.expr(e.right))
+}
+
+func (a astJSON) VisitLogicalExpr(e *LogicalExpr) interface{} {
+	return a.node("LogicalExpr", e.Span(), "left", a.expr(e.left), "operator", e.operator.Lexeme, "right", a.expr(e.right))
+}
+
+func (a astJSON) VisitUnaryExpr(e *UnaryExpr) interface{} {
+	return a.node("UnaryExpr", e.Span(), "operator", e.operator.Lexeme, "right", a.expr(e.right))
+}
+
+func (a astJSON) VisitLiteralExpr(e *LiteralExpr) interface{} {
+	return a.node("LiteralExpr", e.Span(), "value", e.value)
+}
+
+func (a astJSON) VisitVariableExpr(e *VariableExpr) interface{} {
+	return a.node("VariableExpr", e.Span(), "name", e.name.Lexeme)
+}
+
+func (a astJSON) VisitAssignExpr(e *AssignExpr) interface{} {
+	return a.node("AssignExpr", e.Span(), "name", e.name.Lexeme, "value", a.expr(e.value))
+}
+
+func (a astJSON) VisitCallExpr(e *CallExpr) interface{} {
+	return a.node("CallExpr", e.Span(), "callee", a.expr(e.callee), "arguments", a.exprs(e.arguments))
+}
+
+func (a astJSON) VisitGetExpr(e *GetExpr) interface{} {
+	return a.node("GetExpr", e.Span(), "object", a.expr(e.object), "name", e.name.Lexeme)
+}
+
+func (a astJSON) VisitSetExpr(e *SetExpr) interface{} {
+	return a.node("SetExpr", e.Span(), "object", a.expr(e.object), "name", e.name.Lexeme, "value", a.expr(e.value))
+}
+
+func (a astJSON) VisitThisExpr(e *ThisExpr) interface{} {
+	return a.node("ThisExpr", e.Span())
+}
+
+func (a astJSON) VisitSuperExpr(e *SuperExpr) interface{} {
+	return a.node("SuperExpr", e.Span(), "method", e.method.Lexeme)
+}
+
+func (a astJSON) VisitListExpr(e *ListExpr) interface{} {
+	return a.node("ListExpr", e.Span(), "elements", a.exprs(e.elements))
+}
+
+func (a astJSON) VisitMapExpr(e *MapExpr) interface{} {
+	entries := make([]jsonObject, len(e.keys))
+	for idx, key := range e.keys {
+		entries[idx] = jsonObject{{"key", a.expr(key)}, {"value", a.expr(e.values[idx])}}
+	}
+	return a.node("MapExpr", e.Span(), "entries", entries)
+}
+
+func (a astJSON) VisitIndexExpr(e *IndexExpr) interface{} {
+	return a.node("IndexExpr", e.Span(), "object", a.expr(e.object), "index", a.expr(e.index))
+}
+
+func (a astJSON) VisitSetIndexExpr(e *SetIndexExpr) interface{} {
+	return a.node("SetIndexExpr", e.Span(), "object", a.expr(e.object), "index", a.expr(e.index), "value", a.expr(e.value))
+}
+
+func (a astJSON) VisitFunctionExpr(e *FunctionExpr) interface{} {
+	return a.node("FunctionExpr", e.Span(), "params", a.params(e.declaration.params),
+		"body", a.statements(e.declaration.body), "arrow", e.arrow)
+}
+
+func (a astJSON) VisitExprStmt(s *ExprStmt) interface{} {
+	return a.node("ExprStmt", s.Span(), "expression", a.expr(s.expression))
+}
+
+func (a astJSON) VisitPrintStmt(s *PrintStmt) interface{} {
+	return a.node("PrintStmt", s.Span(), "expression", a.expr(s.expression))
+}
+
+func (a astJSON) VisitVariableStmt(s *VariableStmt) interface{} {
+	return a.node("VariableStmt", s.Span(), "name", s.name.Lexeme, "initializer", a.expr(s.initializer))
+}
+
+func (a astJSON) VisitBlockStmt(s *BlockStmt) interface{} {
+	return a.node("BlockStmt", s.Span(), "statements", a.statements(s.statements))
+}
+
+func (a astJSON) VisitIfStmt(s *IfStmt) interface{} {
+	return a.node("IfStmt", s.Span(), "condition", a.expr(s.condition),
+		"thenBranch", a.stmt(s.thenBranch), "elseBranch", a.stmt(s.elseBranch))
+}
+
+func (a astJSON) VisitWhileStmt(s *WhileStmt) interface{} {
+	return a.node("WhileStmt", s.Span(), "condition", a.expr(s.condition), "body", a.stmt(s.body))
+}
+
+func (a astJSON) VisitForStmt(s *ForStmt) interface{} {
+	return a.node("ForStmt", s.Span(), "initializer", a.stmt(s.initializer), "condition", a.expr(s.condition),
+		"increment", a.expr(s.increment), "body", a.stmt(s.body))
+}
+
+func (a astJSON) VisitForInStmt(s *ForInStmt) interface{} {
+	return a.node("ForInStmt", s.Span(), "name", s.name.Lexeme, "iterable", a.expr(s.iterable), "body", a.stmt(s.body))
+}
+
+func (a astJSON) VisitBreakStmt(s *BreakStmt) interface{} {
+	return a.node("BreakStmt", s.Span())
+}
+
+func (a astJSON) VisitContinueStmt(s *ContinueStmt) interface{} {
+	return a.node("ContinueStmt", s.Span())
+}
+
+func (a astJSON) VisitImportStmt(s *ImportStmt) interface{} {
+	var alias interface{}
+	if s.alias != nil {
+		alias = s.alias.Lexeme
+	}
+	return a.node("ImportStmt", s.Span(), "path", s.path.Literal, "alias", alias)
+}
+
+func (a astJSON) VisitThrowStmt(s *ThrowStmt) interface{} {
+	return a.node("ThrowStmt", s.Span(), "value", a.expr(s.value))
+}
+
+func (a astJSON) VisitTryStmt(s *TryStmt) interface{} {
+	var name, catchBody, finallyBody interface{}
+	if s.catchBody != nil {
+		name, catchBody = s.name.Lexeme, a.stmt(s.catchBody)
+	}
+	if s.finallyBody != nil {
+		finallyBody = a.stmt(s.finallyBody)
+	}
+	return a.node("TryStmt", s.Span(), "body", a.stmt(s.body), "name", name,
+		"catchBody", catchBody, "finallyBody", finallyBody)
+}
+
+func (a astJSON) VisitFunctionStmt(s *FunctionStmt) interface{} {
+	return a.node("FunctionStmt", s.Span(), "name", s.name.Lexeme, "params", a.params(s.params), "body", a.statements(s.body))
+}
+
+func (a astJSON) params(params []Token) []string {
+	names := make([]string, len(params))
+	for idx, param := range params {
+		names[idx] = param.Lexeme
+	}
+	return names
+}
+
+func (a astJSON) VisitReturnStmt(s *ReturnStmt) interface{} {
+	return a.node("ReturnStmt", s.Span(), "value", a.expr(s.value))
+}
+
+func (a astJSON) VisitClassStmt(s *ClassStmt) interface{} {
+	var superclass interface{}
+	if s.superclass != nil {
+		superclass = a.expr(s.superclass)
+	}
+	return a.node("ClassStmt", s.Span(), "name", s.name.Lexeme, "superclass", superclass, "methods", a.statements(s.methods))
+}
@@ -0,0 +1,91 @@
+package lox
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalAST(t *testing.T) {
+	got, err := MarshalAST(parseSource(t, "print -x;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "kind": "PrintStmt",
+    "expression": {
+      "kind": "UnaryExpr",
+      "operator": "-",
+      "right": {
+        "kind": "VariableExpr",
+        "name": "x",
+        "span": {
+          "start": {
+            "line": 1,
+            "column": 8,
+            "offset": 7
+          },
+          "end": {
+            "line": 1,
+            "column": 9,
+            "offset": 8
+          }
+        }
+      },
+      "span": {
+        "start": {
+          "line": 1,
+          "column": 7,
+          "offset": 6
+        },
+        "end": {
+          "line": 1,
+          "column": 9,
+          "offset": 8
+        }
+      }
+    },
+    "span": {
+      "start": {
+        "line": 1,
+        "column": 1,
+        "offset": 0
+      },
+      "end": {
+        "line": 1,
+        "column": 10,
+        "offset": 9
+      }
+    }
+  }
+]`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMarshalAST_Corpus(t *testing.T) {
+	source := `class A < B { init(x) { this.x = x; } }
+for (var i = 0; i < 2; i = i + 1) { if (i) print "s"; else return; }
+var f = A(nil).x or true;`
+	data, err := MarshalAST(parseSource(t, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []map[string]interface{}
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{"ClassStmt", "ForStmt", "VariableStmt"}
+	if len(nodes) != len(kinds) {
+		t.Fatalf("expected %d statements, got %d", len(kinds), len(nodes))
+	}
+	for idx, kind := range kinds {
+		if nodes[idx]["kind"] != kind {
+			t.Errorf("statement %d: got kind %v, want %s", idx, nodes[idx]["kind"], kind)
+		}
+	}
+	if super := nodes[0]["superclass"].(map[string]interface{}); super["name"] != "B" {
+		t.Errorf("unexpected superclass %v", super)
+	}
+}
@@ -5,6 +5,13 @@ import (
 	"strings"
 )
 
+// AstPrinter renders the AST as S-expressions, one line per top-level
+// statement:
+//
+//	var a = 1 + 2;   →  (var a (+ 1 2))
+//	print a.b(c);    →  (print (call (. a b) c))
+//
+// Missing optional parts, like the clauses of for (;;), print as _.
 type AstPrinter struct {
 }
 
@@ -12,10 +19,14 @@ func NewAstPrinter() *AstPrinter {
 	return &AstPrinter{}
 }
 
-func (p *AstPrinter) Print(statements []Stmt) {
+// Print returns the S-expressions of statements, each on its own line.
+func (p *AstPrinter) Print(statements []Stmt) string {
+	var builder strings.Builder
 	for _, stmt := range statements {
-		p.print(stmt)
+		builder.WriteString(p.print(stmt))
+		builder.WriteString("\n")
 	}
+	return builder.String()
 }
 
 func (p *AstPrinter) PrintExpr(e Expr) string {
@@ -29,13 +40,33 @@ func (p *AstPrinter) parenthesize(name string, exprs ...Expr) interface{} {
 
 	for _, expr := range exprs {
 		builder.WriteString(" ")
-		result := expr.Accept(p)
-		builder.WriteString(fmt.Sprintf("%v", result))
+		builder.WriteString(p.expr(expr))
 	}
 	builder.WriteString(")")
 	return builder.String()
 }
 
+// list joins already printed parts into one S-expression.
+func (p *AstPrinter) list(parts ...string) string {
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// expr prints e, or _ when it is absent.
+func (p *AstPrinter) expr(e Expr) string {
+	if e == nil {
+		return "_"
+	}
+	return fmt.Sprintf("%v", e.Accept(p))
+}
+
+// print prints stmt, or _ when it is absent.
+func (p *AstPrinter) print(stmt Stmt) string {
+	if stmt == nil {
+		return "_"
+	}
+	return fmt.Sprintf("%v", stmt.Accept(p))
+}
+
 func (p *AstPrinter) VisitGroupExpr(e *GroupExpr) interface{} {
 	return p.parenthesize("group", e.expression)
 }
@@ -44,25 +75,178 @@ func (p *AstPrinter) VisitBinaryExpr(e *BinaryExpr) interface{} {
 	return p.parenthesize(e.operator.Lexeme, e.left, e.right)
 }
 
+func (p *AstPrinter) VisitLogicalExpr(e *LogicalExpr) interface{} {
+	return p.parenthesize(e.operator.Lexeme, e.left, e.right)
+}
+
 func (p *AstPrinter) VisitUnaryExpr(e *UnaryExpr) interface{} {
 	return p.parenthesize(e.operator.Lexeme, e.right)
 }
 
 func (p *AstPrinter) VisitLiteralExpr(e *LiteralExpr) interface{} {
-	if e.value == nil {
+	switch value := e.value.(type) {
+	case nil:
 		return "nil"
+	case string:
+		return fmt.Sprintf("%q", value)
+	case float64:
+		return stringify(value)
 	}
 	return fmt.Sprintf("%v", e.value)
 }
 
-func (p *AstPrinter) print(stmt Stmt) {
-	stmt.Accept(p)
+func (p *AstPrinter) VisitVariableExpr(e *VariableExpr) interface{} {
+	return e.name.Lexeme
+}
+
+func (p *AstPrinter) VisitAssignExpr(e *AssignExpr) interface{} {
+	return p.list("=", e.name.Lexeme, p.expr(e.value))
+}
+
+func (p *AstPrinter) VisitCallExpr(e *CallExpr) interface{} {
+	return p.parenthesize("call", append([]Expr{e.callee}, e.arguments...)...)
+}
+
+func (p *AstPrinter) VisitGetExpr(e *GetExpr) interface{} {
+	return p.list(".", p.expr(e.object), e.name.Lexeme)
+}
+
+func (p *AstPrinter) VisitSetExpr(e *SetExpr) interface{} {
+	return p.list("=", p.list(".", p.expr(e.object), e.name.Lexeme), p.expr(e.value))
+}
+
+func (p *AstPrinter) VisitThisExpr(e *ThisExpr) interface{} {
+	return "this"
+}
+
+func (p *AstPrinter) VisitSuperExpr(e *SuperExpr) interface{} {
+	return p.list(".", "super", e.method.Lexeme)
+}
+
+func (p *AstPrinter) VisitListExpr(e *ListExpr) interface{} {
+	return p.parenthesize("list", e.elements...)
+}
+
+func (p *AstPrinter) VisitMapExpr(e *MapExpr) interface{} {
+	parts := []string{"map"}
+	for idx, key := range e.keys {
+		parts = append(parts, p.expr(key), p.expr(e.values[idx]))
+	}
+	return p.list(parts...)
+}
+
+func (p *AstPrinter) VisitIndexExpr(e *IndexExpr) interface{} {
+	return p.parenthesize("[]", e.object, e.index)
+}
+
+func (p *AstPrinter) VisitSetIndexExpr(e *SetIndexExpr) interface{} {
+	return p.list("=", p.parenthesize("[]", e.object, e.index).(string), p.expr(e.value))
+}
+
+func (p *AstPrinter) VisitFunctionExpr(e *FunctionExpr) interface{} {
+	fn := e.declaration
+	return p.list(append([]string{"lambda", p.params(fn.params)}, p.statements(fn.body)...)...)
+}
+
+func (p *AstPrinter) VisitExprStmt(s *ExprStmt) interface{} {
+	return p.parenthesize(";", s.expression)
+}
+
+func (p *AstPrinter) VisitPrintStmt(s *PrintStmt) interface{} {
+	return p.parenthesize("print", s.expression)
 }
 
 func (p *AstPrinter) VisitVariableStmt(s *VariableStmt) interface{} {
-	return p.parenthesize(s.name.Lexeme, s.initializer)
+	if s.initializer == nil {
+		return p.list("var", s.name.Lexeme)
+	}
+	return p.list("var", s.name.Lexeme, p.expr(s.initializer))
 }
 
-func (p *AstPrinter) VisitVariableExpr(e *VariableExpr) interface{} {
-	return p.parenthesize(e.name.Lexeme)
+func (p *AstPrinter) VisitBlockStmt(s *BlockStmt) interface{} {
+	return p.list(append([]string{"block"}, p.statements(s.statements)...)...)
+}
+
+func (p *AstPrinter) VisitIfStmt(s *IfStmt) interface{} {
+	if s.elseBranch == nil {
+		return p.list("if", p.expr(s.condition), p.print(s.thenBranch))
+	}
+	return p.list("if", p.expr(s.condition), p.print(s.thenBranch), p.print(s.elseBranch))
+}
+
+func (p *AstPrinter) VisitWhileStmt(s *WhileStmt) interface{} {
+	return p.list("while", p.expr(s.condition), p.print(s.body))
+}
+
+func (p *AstPrinter) VisitForStmt(s *ForStmt) interface{} {
+	return p.list("for", p.print(s.initializer), p.expr(s.condition), p.expr(s.increment), p.print(s.body))
+}
+
+func (p *AstPrinter) VisitForInStmt(s *ForInStmt) interface{} {
+	return p.list("for-in", s.name.Lexeme, p.expr(s.iterable), p.print(s.body))
+}
+
+func (p *AstPrinter) VisitBreakStmt(s *BreakStmt) interface{} {
+	return "(break)"
+}
+
+func (p *AstPrinter) VisitContinueStmt(s *ContinueStmt) interface{} {
+	return "(continue)"
+}
+
+func (p *AstPrinter) VisitImportStmt(s *ImportStmt) interface{} {
+	if s.alias == nil {
+		return p.list("import", s.path.Lexeme)
+	}
+	return p.list("import", s.path.Lexeme, "as", s.alias.Lexeme)
+}
+
+func (p *AstPrinter) VisitThrowStmt(s *ThrowStmt) interface{} {
+	return p.parenthesize("throw", s.value)
+}
+
+func (p *AstPrinter) VisitTryStmt(s *TryStmt) interface{} {
+	parts := []string{"try", p.print(s.body)}
+	if s.catchBody != nil {
+		parts = append(parts, p.list(append([]string{"catch", s.name.Lexeme}, p.statements(s.catchBody.statements)...)...))
+	}
+	if s.finallyBody != nil {
+		parts = append(parts, p.list("finally", p.print(s.finallyBody)))
+	}
+	return p.list(parts...)
+}
+
+func (p *AstPrinter) VisitFunctionStmt(s *FunctionStmt) interface{} {
+	return p.list(append([]string{"fun", s.name.Lexeme, p.params(s.params)}, p.statements(s.body)...)...)
+}
+
+func (p *AstPrinter) VisitReturnStmt(s *ReturnStmt) interface{} {
+	if s.value == nil {
+		return "(return)"
+	}
+	return p.parenthesize("return", s.value)
+}
+
+func (p *AstPrinter) VisitClassStmt(s *ClassStmt) interface{} {
+	parts := []string{"class", s.name.Lexeme}
+	if s.superclass != nil {
+		parts = append(parts, "<", s.superclass.name.Lexeme)
+	}
+	return p.list(append(parts, p.statements(s.methods)...)...)
+}
+
+func (p *AstPrinter) statements(statements []Stmt) []string {
+	printed := make([]string, len(statements))
+	for idx, stmt := range statements {
+		printed[idx] = p.print(stmt)
+	}
+	return printed
+}
+
+func (p *AstPrinter) params(params []Token) string {
+	names := make([]string, len(params))
+	for idx, param := range params {
+		names[idx] = param.Lexeme
+	}
+	return "(" + strings.Join(names, " ") + ")"
 }
@@ -7,7 +7,7 @@ import (
 
 func TestPrintAst(t *testing.T) {
 
-	e := BinaryExpr{
+	e := &BinaryExpr{
 		left: &UnaryExpr{
 			operator: Token{
 				TokenType: MINUS,
@@ -15,7 +15,7 @@ func TestPrintAst(t *testing.T) {
 				Literal:   nil,
 				Line:      1,
 			},
-			right: LiteralExpr{value: 123},
+			right: &LiteralExpr{value: 123},
 		},
 		operator: Token{
 			TokenType: STAR,
@@ -23,8 +23,8 @@ func TestPrintAst(t *testing.T) {
 			Literal:   nil,
 			Line:      1,
 		},
-		right: GroupExpr{
-			expression: LiteralExpr{value: 45.67},
+		right: &GroupExpr{
+			expression: &LiteralExpr{value: 45.67},
 		},
 	}
 
@@ -51,3 +51,54 @@ func TestPrintAst(t *testing.T) {
 
 	*/
 }
+
+func parseSource(t *testing.T, source string) []Stmt {
+	t.Helper()
+	scanner := NewScanner()
+	if err := scanner.Eval(source); err != nil {
+		t.Fatal(err)
+	}
+	statements, err := NewParser(scanner.Tokens).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statements
+}
+
+func TestPrintAst_Statements(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`var a = 1 + 2;`, `(var a (+ 1 2))`},
+		{`var b;`, `(var b)`},
+		{`print a.b(c, "d");`, `(print (call (. a b) c "d"))`},
+		{`a.b = !c or nil;`, `(; (= (. a b) (or (! c) nil)))`},
+		{`{ x = 1; }`, `(block (; (= x 1)))`},
+		{`if (a) print 1; else print 2;`, `(if a (print 1) (print 2))`},
+		{`while (true) {}`, `(while true (block))`},
+		{`for (;;) print 1;`, `(for _ _ _ (print 1))`},
+		{`for (var i = 0; i < 3; i = i + 1) print i;`, `(for (var i 0) (< i 3) (= i (+ i 1)) (print i))`},
+		{`fun f(x, y) { return x; }`, `(fun f (x y) (return x))`},
+		{`fun g() { return; }`, `(fun g () (return))`},
+		{`class B < A { m() { super.m(); this.n; } }`, `(class B < A (fun m () (; (call (. super m))) (; (. this n))))`},
+		{`print [1, [a]][0];`, `(print ([] (list 1 (list a)) 0))`},
+		{`m["k"] = {"a": 1, b: []};`, `(; (= ([] m "k") (map "a" 1 b (list))))`},
+		{`for (var x in xs) print x;`, `(for-in x xs (print x))`},
+		{`while (a) { break; continue; }`, `(while a (block (break) (continue)))`},
+		{`var f = fun (a, b) { print a; };`, `(var f (lambda (a b) (print a)))`},
+		{`xs.map(x => x + 1);`, `(; (call (. xs map) (lambda (x) (return (+ x 1)))))`},
+		{`var g = () => {};`, `(var g (lambda ()))`},
+		{`import "a/b.lox" as b; import "c.lox";`, "(import \"a/b.lox\" as b)\n(import \"c.lox\")"},
+		{`try { f(); } catch (e) { throw e; } finally { g(); }`,
+			`(try (block (; (call f))) (catch e (throw e)) (finally (block (; (call g)))))`},
+		{`try {} finally {}`, `(try (block) (finally (block)))`},
+	}
+	printer := NewAstPrinter()
+	for _, test := range tests {
+		got := printer.Print(parseSource(t, test.source))
+		if got != test.want+"\n" {
+			t.Errorf("%s: got %s, want %s", test.source, got, test.want)
+		}
+	}
+}
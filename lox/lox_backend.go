@@ -0,0 +1,51 @@
+package lox
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Backend executes parsed Lox programs. Interpreter walks the AST directly;
+// VM compiles it to bytecode first. Both run the LoxResolver's static checks
+// before executing anything, define the same standard library natives and
+// keep their globals between calls. Errors Interpret returns are also
+// written to the backend's diagnostics writer; a runtime error carries the
+// stack trace of the calls active when it was raised.
+//
+// For scripts that are not trusted, SetLimits bounds the work a program may
+// do, InterpretContext stops it once a context is done and SetCapabilities
+// withholds natives and statements that reach outside the program.
+type Backend interface {
+	Interpret(statements []Stmt) error
+	InterpretContext(ctx context.Context, statements []Stmt) error
+	DefineNative(name string, arity int, fn NativeFunc)
+	DefineNativeCallback(name string, arity int, fn NativeCallbackFunc)
+	SetInput(in io.Reader)
+	SetSearchPath(dirs ...string)
+	SetMaxCallDepth(depth int)
+	SetLimits(limits Limits)
+	SetCapabilities(caps Capability)
+	Get(name string) (Value, bool)
+	Set(name string, value Value)
+}
+
+type BackendKind int
+
+const (
+	TreeWalker BackendKind = iota
+	Bytecode
+)
+
+func NewBackend(kind BackendKind) Backend {
+	return NewBackendWithOutput(kind, os.Stdout, os.Stderr)
+}
+
+// NewBackendWithOutput returns a backend that prints to out and reports
+// errors to diagnostics.
+func NewBackendWithOutput(kind BackendKind, out io.Writer, diagnostics io.Writer) Backend {
+	if kind == Bytecode {
+		return NewVMWithOutput(out, diagnostics)
+	}
+	return NewInterpreterWithOutput(out, diagnostics)
+}
@@ -1,13 +1,58 @@
 package lox
 
-import "time"
+// NativeFunc is the Go implementation behind a function registered with
+// Interpreter.DefineNative.
+type NativeFunc func(args []Value) (Value, error)
 
-type ClockFunction struct{}
+// Caller calls a Lox function, class or native from Go, checking the
+// arguments as a call expression does. A native must return any error its
+// Caller reports unchanged: the backend has already stopped the program.
+type Caller func(callee Value, args ...Value) (Value, error)
 
-func (c *ClockFunction) Arity() int {
-	return 0
+// NativeCallbackFunc is the Go implementation of a native that calls back
+// into Lox, such as one taking a callback argument.
+type NativeCallbackFunc func(call Caller, args []Value) (Value, error)
+
+type NativeFunction struct {
+	name  string
+	arity int
+	fn    NativeCallbackFunc
+}
+
+func NewNativeFunction(name string, arity int, fn NativeFunc) *NativeFunction {
+	return NewNativeCallbackFunction(name, arity, func(call Caller, args []Value) (Value, error) {
+		return fn(args)
+	})
+}
+
+func NewNativeCallbackFunction(name string, arity int, fn NativeCallbackFunc) *NativeFunction {
+	return &NativeFunction{name: name, arity: arity, fn: fn}
+}
+
+func (n *NativeFunction) Arity() int {
+	return n.arity
+}
+
+// Call is used when the native is invoked by another callable rather than a
+// call expression, so an error is attributed to the last call site of the
+// calling frame, as it is for a LoxFunction.
+func (n *NativeFunction) Call(i *Interpreter, arguments ...interface{}) interface{} {
+	result, err := n.invoke(i.callback, arguments)
+	if err != nil {
+		call := NewToken(IDENTIFIER, n.name, nil, 0)
+		if len(i.frames) > 0 {
+			call = i.frames[len(i.frames)-1].call
+		}
+		i.error(call, err.Error())
+	}
+	return result
+}
+
+func (n *NativeFunction) String() string {
+	return "<native fn>"
 }
 
-func (c *ClockFunction) Call(i *Interpreter, arguments ...interface{}) interface{} {
-	return time.Now().Second()
+func (n *NativeFunction) invoke(call Caller, arguments []interface{}) (interface{}, error) {
+	result, err := n.fn(call, arguments)
+	return toLoxValue(result), err
 }
@@ -0,0 +1,221 @@
+package lox
+
+import (
+	"fmt"
+	"strings"
+)
+
+type OpCode byte
+
+const (
+	OpConstant OpCode = iota
+	OpNil
+	OpTrue
+	OpFalse
+	OpPop
+	OpGetLocal
+	OpSetLocal
+	OpGetGlobal
+	OpDefineGlobal
+	OpSetGlobal
+	OpGetUpvalue
+	OpSetUpvalue
+	OpGetProperty
+	OpSetProperty
+	OpGetSuper
+	OpEqual
+	OpGreater
+	OpGreaterEqual
+	OpLess
+	OpLessEqual
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpNot
+	OpNegate
+	OpPrint
+	OpJump
+	OpJumpIfFalse
+	OpLoop
+	OpCall
+	OpInvoke
+	OpSuperInvoke
+	OpClosure
+	OpCloseUpvalue
+	OpReturn
+	OpClass
+	OpInherit
+	OpMethod
+	OpList
+	OpMap
+	OpGetIndex
+	OpSetIndex
+	OpIterable
+	OpForIter
+	OpImport
+	OpImportAll
+	OpThrow
+	OpRethrow
+	OpTryCatch
+	OpTryFinally
+	OpEndTry
+)
+
+var opNames = [...]string{
+	OpConstant:     "OP_CONSTANT",
+	OpNil:          "OP_NIL",
+	OpTrue:         "OP_TRUE",
+	OpFalse:        "OP_FALSE",
+	OpPop:          "OP_POP",
+	OpGetLocal:     "OP_GET_LOCAL",
+	OpSetLocal:     "OP_SET_LOCAL",
+	OpGetGlobal:    "OP_GET_GLOBAL",
+	OpDefineGlobal: "OP_DEFINE_GLOBAL",
+	OpSetGlobal:    "OP_SET_GLOBAL",
+	OpGetUpvalue:   "OP_GET_UPVALUE",
+	OpSetUpvalue:   "OP_SET_UPVALUE",
+	OpGetProperty:  "OP_GET_PROPERTY",
+	OpSetProperty:  "OP_SET_PROPERTY",
+	OpGetSuper:     "OP_GET_SUPER",
+	OpEqual:        "OP_EQUAL",
+	OpGreater:      "OP_GREATER",
+	OpGreaterEqual: "OP_GREATER_EQUAL",
+	OpLess:         "OP_LESS",
+	OpLessEqual:    "OP_LESS_EQUAL",
+	OpAdd:          "OP_ADD",
+	OpSubtract:     "OP_SUBTRACT",
+	OpMultiply:     "OP_MULTIPLY",
+	OpDivide:       "OP_DIVIDE",
+	OpNot:          "OP_NOT",
+	OpNegate:       "OP_NEGATE",
+	OpPrint:        "OP_PRINT",
+	OpJump:         "OP_JUMP",
+	OpJumpIfFalse:  "OP_JUMP_IF_FALSE",
+	OpLoop:         "OP_LOOP",
+	OpCall:         "OP_CALL",
+	OpInvoke:       "OP_INVOKE",
+	OpSuperInvoke:  "OP_SUPER_INVOKE",
+	OpClosure:      "OP_CLOSURE",
+	OpCloseUpvalue: "OP_CLOSE_UPVALUE",
+	OpReturn:       "OP_RETURN",
+	OpClass:        "OP_CLASS",
+	OpInherit:      "OP_INHERIT",
+	OpMethod:       "OP_METHOD",
+	OpList:         "OP_LIST",
+	OpMap:          "OP_MAP",
+	OpGetIndex:     "OP_GET_INDEX",
+	OpSetIndex:     "OP_SET_INDEX",
+	OpIterable:     "OP_ITERABLE",
+	OpForIter:      "OP_FOR_ITER",
+	OpImport:       "OP_IMPORT",
+	OpImportAll:    "OP_IMPORT_ALL",
+	OpThrow:        "OP_THROW",
+	OpRethrow:      "OP_RETHROW",
+	OpTryCatch:     "OP_TRY_CATCH",
+	OpTryFinally:   "OP_TRY_FINALLY",
+	OpEndTry:       "OP_END_TRY",
+}
+
+func (op OpCode) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("OP_UNKNOWN(%d)", byte(op))
+}
+
+// Chunk is the bytecode of one function. Constant operands are two bytes
+// wide, local, upvalue and argument-count operands one byte. OpList and
+// OpMap take a two-byte element count. OpTryCatch and OpTryFinally take the
+// two-byte forward jump to their handler, like OpJump.
+type Chunk struct {
+	Code      []byte
+	Lines     []int
+	Constants []Value
+}
+
+func NewChunk() *Chunk {
+	return &Chunk{}
+}
+
+func (c *Chunk) write(b byte, line int) {
+	c.Code = append(c.Code, b)
+	c.Lines = append(c.Lines, line)
+}
+
+func (c *Chunk) addConstant(value Value) int {
+	c.Constants = append(c.Constants, value)
+	return len(c.Constants) - 1
+}
+
+func (c *Chunk) readShort(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
+
+// Disassemble renders the chunk one instruction per line, for debugging the
+// compiler.
+func (c *Chunk) Disassemble(name string) string {
+	var builder strings.Builder
+	builder.WriteString("== " + name + " ==\n")
+	for offset := 0; offset < len(c.Code); {
+		offset = c.disassembleInstruction(&builder, offset)
+	}
+	return builder.String()
+}
+
+func (c *Chunk) disassembleInstruction(b *strings.Builder, offset int) int {
+	fmt.Fprintf(b, "%04d ", offset)
+	if offset > 0 && c.Lines[offset] == c.Lines[offset-1] {
+		b.WriteString("   | ")
+	} else {
+		fmt.Fprintf(b, "%4d ", c.Lines[offset])
+	}
+
+	op := OpCode(c.Code[offset])
+	switch op {
+	case OpConstant, OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty,
+		OpSetProperty, OpGetSuper, OpClass, OpMethod, OpImport:
+		constant := c.readShort(offset + 1)
+		fmt.Fprintf(b, "%-16s %4d '%v'\n", op, constant, c.Constants[constant])
+		return offset + 3
+	case OpList, OpMap:
+		fmt.Fprintf(b, "%-16s %4d\n", op, c.readShort(offset+1))
+		return offset + 3
+	case OpForIter:
+		jump := c.readShort(offset + 2)
+		fmt.Fprintf(b, "%-16s %4d -> %d\n", op, c.Code[offset+1], offset+4+jump)
+		return offset + 4
+	case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall:
+		fmt.Fprintf(b, "%-16s %4d\n", op, c.Code[offset+1])
+		return offset + 2
+	case OpJump, OpJumpIfFalse, OpTryCatch, OpTryFinally:
+		jump := c.readShort(offset + 1)
+		fmt.Fprintf(b, "%-16s %4d -> %d\n", op, offset, offset+3+jump)
+		return offset + 3
+	case OpLoop:
+		jump := c.readShort(offset + 1)
+		fmt.Fprintf(b, "%-16s %4d -> %d\n", op, offset, offset+3-jump)
+		return offset + 3
+	case OpInvoke, OpSuperInvoke:
+		constant := c.readShort(offset + 1)
+		fmt.Fprintf(b, "%-16s (%d args) %4d '%v'\n", op, c.Code[offset+3], constant, c.Constants[constant])
+		return offset + 4
+	case OpClosure:
+		constant := c.readShort(offset + 1)
+		fn := c.Constants[constant].(*vmFunction)
+		fmt.Fprintf(b, "%-16s %4d %v\n", op, constant, fn)
+		offset += 3
+		for j := 0; j < fn.upvalueCount; j++ {
+			kind := "upvalue"
+			if c.Code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(b, "%04d    |                     %s %d\n", offset, kind, c.Code[offset+1])
+			offset += 2
+		}
+		return offset
+	default:
+		fmt.Fprintf(b, "%s\n", op)
+		return offset + 1
+	}
+}
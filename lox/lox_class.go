@@ -3,7 +3,7 @@ package lox
 type LoxClass struct {
 	name       string
 	superclass *LoxClass
-	methods    map[string]LoxCallable
+	methods    map[string]*LoxFunction
 }
 
 type LoxInstance struct {
@@ -11,8 +11,8 @@ type LoxInstance struct {
 	fields map[string]interface{}
 }
 
-func NewLoxClass(name string, methods map[string]LoxCallable) *LoxClass {
-	return &LoxClass{name: name, methods: methods}
+func NewLoxClass(name string, superclass *LoxClass, methods map[string]*LoxFunction) *LoxClass {
+	return &LoxClass{name: name, superclass: superclass, methods: methods}
 }
 
 func NewLoxInstance(class *LoxClass) *LoxInstance {
@@ -24,19 +24,25 @@ func (c *LoxClass) Arity() int {
 	if initializer == nil {
 		return 0
 	}
-	return initializer.(*LoxFunction).Arity()
+	return initializer.Arity()
 }
 
 func (c *LoxClass) Call(i *Interpreter, arguments ...interface{}) interface{} {
 	instance := NewLoxInstance(c)
 	initializer := c.findMethod("init")
 	if initializer != nil {
-		initializer.(*LoxFunction).bind(instance).(*LoxFunction).Call(i, arguments)
+		initializer.bind(instance).Call(i, arguments...)
 	}
 	return instance
 }
 
-func (c *LoxClass) findMethod(name string) interface{} {
+func (c *LoxClass) String() string {
+	return c.name
+}
+
+// findMethod looks name up on the class and then its superclasses, returning
+// nil when no class in the chain defines it.
+func (c *LoxClass) findMethod(name string) *LoxFunction {
 	method, ok := c.methods[name]
 	if ok {
 		return method
@@ -48,23 +54,27 @@ func (c *LoxClass) findMethod(name string) interface{} {
 	return nil
 }
 
-func (i *LoxInstance) Get(name string) interface{} {
+// Get returns the field called name or, failing that, the method called name
+// bound to this instance. It reports false when neither exists.
+func (i *LoxInstance) Get(name string) (interface{}, bool) {
 	value, ok := i.fields[name]
 	if ok {
-		return value
+		return value, true
 	}
 
 	method := i.class.findMethod(name)
 
 	if method != nil {
-		method.(*LoxFunction).bind(i)
-		return method
+		return method.bind(i), true
 	}
 
-	//TODO throw error!
-	return nil
+	return nil, false
 }
 
 func (i *LoxInstance) Set(name string, value interface{}) {
 	i.fields[name] = value
 }
+
+func (i *LoxInstance) String() string {
+	return i.class.name + " instance"
+}
@@ -0,0 +1,301 @@
+package lox
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// LoxList is a growable list of values, created by a list literal.
+type LoxList struct {
+	elements []Value
+}
+
+// LoxMap maps strings, numbers and booleans to values. It remembers the
+// order keys were first added, which is the order keys() returns and print
+// shows.
+type LoxMap struct {
+	entries map[Value]Value
+	keys    []Value
+}
+
+func NewLoxList(elements []Value) *LoxList {
+	return &LoxList{elements: elements}
+}
+
+func NewLoxMap() *LoxMap {
+	return &LoxMap{entries: make(map[Value]Value)}
+}
+
+// Get returns the value stored under key, or nil when there is none.
+func (m *LoxMap) Get(key Value) (Value, error) {
+	if err := checkMapKey(key); err != nil {
+		return nil, err
+	}
+	return m.entries[key], nil
+}
+
+func (m *LoxMap) Set(key Value, value Value) error {
+	if err := checkMapKey(key); err != nil {
+		return err
+	}
+	if _, ok := m.entries[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.entries[key] = value
+	return nil
+}
+
+func (m *LoxMap) remove(key Value) (Value, bool) {
+	value, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	delete(m.entries, key)
+	for idx, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:idx], m.keys[idx+1:]...)
+			break
+		}
+	}
+	return value, true
+}
+
+// checkMapKey rejects keys without a stable value to hash. NaN is excluded
+// too, as it never equals itself.
+func checkMapKey(key Value) error {
+	switch k := key.(type) {
+	case string, bool:
+		return nil
+	case float64:
+		if !math.IsNaN(k) {
+			return nil
+		}
+	}
+	return errors.New("Map keys must be strings, numbers or booleans.")
+}
+
+// listIndex checks that index is a whole number within the list.
+func (l *LoxList) listIndex(index Value) (int, error) {
+	n, ok := index.(float64)
+	if !ok || n != math.Trunc(n) {
+		return 0, errors.New("List index must be a whole number.")
+	}
+	if n < 0 || n >= float64(len(l.elements)) {
+		return 0, fmt.Errorf("List index %s out of range for length %d.", stringify(n), len(l.elements))
+	}
+	return int(n), nil
+}
+
+// getIndex implements object[index] for both backends.
+func getIndex(object Value, index Value) (Value, error) {
+	switch o := object.(type) {
+	case *LoxList:
+		idx, err := o.listIndex(index)
+		if err != nil {
+			return nil, err
+		}
+		return o.elements[idx], nil
+	case *LoxMap:
+		return o.Get(index)
+	}
+	return nil, errors.New("Only lists and maps can be indexed.")
+}
+
+// setIndex implements object[index] = value for both backends.
+func setIndex(object Value, index Value, value Value) error {
+	switch o := object.(type) {
+	case *LoxList:
+		idx, err := o.listIndex(index)
+		if err != nil {
+			return err
+		}
+		o.elements[idx] = value
+		return nil
+	case *LoxMap:
+		return o.Set(index, value)
+	}
+	return errors.New("Only lists and maps can be indexed.")
+}
+
+// iterate returns what a for-in loop visits: the elements of a list or the
+// keys of a map. The keys are a snapshot, so the loop may change the map.
+func iterate(object Value) ([]Value, error) {
+	switch o := object.(type) {
+	case *LoxList:
+		return o.elements, nil
+	case *LoxMap:
+		return append([]Value(nil), o.keys...), nil
+	}
+	return nil, errors.New("Can only iterate over lists and maps.")
+}
+
+// collectionMethod returns the native method name of a list or map, bound
+// to it.
+func collectionMethod(object Value, name string) (*NativeFunction, bool) {
+	switch o := object.(type) {
+	case *LoxList:
+		return o.method(name)
+	case *LoxMap:
+		return o.method(name)
+	}
+	return nil, false
+}
+
+func (l *LoxList) method(name string) (*NativeFunction, bool) {
+	switch name {
+	case "push":
+		return NewNativeFunction(name, 1, func(args []Value) (Value, error) {
+			l.elements = append(l.elements, args[0])
+			return nil, nil
+		}), true
+	case "pop":
+		return NewNativeFunction(name, 0, func(args []Value) (Value, error) {
+			if len(l.elements) == 0 {
+				return nil, errors.New("Can't pop from an empty list.")
+			}
+			last := l.elements[len(l.elements)-1]
+			l.elements = l.elements[:len(l.elements)-1]
+			return last, nil
+		}), true
+	case "contains":
+		return NewNativeFunction(name, 1, func(args []Value) (Value, error) {
+			for _, element := range l.elements {
+				if isEqual(element, args[0]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}), true
+	case "map":
+		return NewNativeCallbackFunction(name, 1, func(call Caller, args []Value) (Value, error) {
+			mapped := make([]Value, 0, len(l.elements))
+			for _, element := range l.elements {
+				value, err := call(args[0], element)
+				if err != nil {
+					return nil, err
+				}
+				mapped = append(mapped, value)
+			}
+			return NewLoxList(mapped), nil
+		}), true
+	case "filter":
+		return NewNativeCallbackFunction(name, 1, func(call Caller, args []Value) (Value, error) {
+			var kept []Value
+			for _, element := range l.elements {
+				keep, err := call(args[0], element)
+				if err != nil {
+					return nil, err
+				}
+				if isTruthy(keep) {
+					kept = append(kept, element)
+				}
+			}
+			return NewLoxList(kept), nil
+		}), true
+	}
+	return nil, false
+}
+
+func (m *LoxMap) method(name string) (*NativeFunction, bool) {
+	switch name {
+	case "keys":
+		return NewNativeFunction(name, 0, func(args []Value) (Value, error) {
+			return NewLoxList(append([]Value(nil), m.keys...)), nil
+		}), true
+	case "values":
+		return NewNativeFunction(name, 0, func(args []Value) (Value, error) {
+			values := make([]Value, len(m.keys))
+			for idx, key := range m.keys {
+				values[idx] = m.entries[key]
+			}
+			return NewLoxList(values), nil
+		}), true
+	case "has":
+		return NewNativeFunction(name, 1, func(args []Value) (Value, error) {
+			if err := checkMapKey(args[0]); err != nil {
+				return nil, err
+			}
+			_, ok := m.entries[args[0]]
+			return ok, nil
+		}), true
+	case "remove":
+		return NewNativeFunction(name, 1, func(args []Value) (Value, error) {
+			if err := checkMapKey(args[0]); err != nil {
+				return nil, err
+			}
+			value, _ := m.remove(args[0])
+			return value, nil
+		}), true
+	}
+	return nil, false
+}
+
+// length is the len native: the number of elements of a list, entries of a
+// map or characters of a string.
+func length(args []Value) (Value, error) {
+	switch v := args[0].(type) {
+	case *LoxList:
+		return len(v.elements), nil
+	case *LoxMap:
+		return len(v.keys), nil
+	case string:
+		return utf8.RuneCountInString(v), nil
+	}
+	return nil, errors.New("Only lists, maps and strings have a length.")
+}
+
+func (l *LoxList) String() string {
+	return formatCollection(l, map[Value]bool{})
+}
+
+func (m *LoxMap) String() string {
+	return formatCollection(m, map[Value]bool{})
+}
+
+// formatCollection renders a list or map with its strings quoted. A
+// collection that contains itself prints the inner occurrence as [...] or
+// {...}.
+func formatCollection(value Value, seen map[Value]bool) string {
+	var b strings.Builder
+	switch v := value.(type) {
+	case *LoxList:
+		if seen[v] {
+			return "[...]"
+		}
+		seen[v] = true
+		b.WriteString("[")
+		for idx, element := range v.elements {
+			if idx > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(formatCollection(element, seen))
+		}
+		b.WriteString("]")
+		delete(seen, v)
+	case *LoxMap:
+		if seen[v] {
+			return "{...}"
+		}
+		seen[v] = true
+		b.WriteString("{")
+		for idx, key := range v.keys {
+			if idx > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(formatCollection(key, seen))
+			b.WriteString(": ")
+			b.WriteString(formatCollection(v.entries[key], seen))
+		}
+		b.WriteString("}")
+		delete(seen, v)
+	case string:
+		return strconv.Quote(v)
+	default:
+		return stringify(value)
+	}
+	return b.String()
+}
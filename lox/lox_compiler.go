@@ -0,0 +1,937 @@
+package lox
+
+const (
+	maxLocals    = 256
+	maxUpvalues  = 256
+	maxConstants = 1 << 16
+	maxJump      = 1<<16 - 1
+)
+
+type local struct {
+	name       string
+	depth      int // -1 between declaration and initialization
+	isCaptured bool
+}
+
+type upvalueRef struct {
+	index   byte
+	isLocal bool
+}
+
+// funcCompiler holds the state of the function currently being compiled.
+// Nested function declarations push a new one linked through enclosing.
+type funcCompiler struct {
+	enclosing  *funcCompiler
+	function   *vmFunction
+	ftype      FunctionType
+	locals     []local
+	upvalues   []upvalueRef
+	scopeDepth int
+	names      map[string]int
+	loop       *loopCompiler
+	try        *tryCompiler
+}
+
+// loopCompiler collects the jumps of the break and continue statements in
+// a loop body until their targets are known. scopeDepth is the depth of the
+// scope enclosing the body; the statements pop every local deeper than it.
+type loopCompiler struct {
+	enclosing  *loopCompiler
+	scopeDepth int
+	breaks     []int
+	continues  []int
+}
+
+// tryCompiler is a try statement whose body or catch clause is being
+// compiled. handlers counts the handlers it has pushed at that point; a
+// jump out of the statement pops them and runs finally first. scopeDepth is
+// the depth of the scope enclosing the statement and loop the loop around
+// it.
+type tryCompiler struct {
+	enclosing  *tryCompiler
+	handlers   int
+	finally    *BlockStmt
+	scopeDepth int
+	loop       *loopCompiler
+}
+
+type classCompiler struct {
+	enclosing     *classCompiler
+	hasSuperclass bool
+}
+
+// Compiler turns a resolved []Stmt into bytecode for the VM. Like
+// LoxResolver it walks the AST as a Visitor, but it assigns every local a
+// stack slot and every captured variable an upvalue instead of recording
+// scope distances.
+type Compiler struct {
+	current *funcCompiler
+	class   *classCompiler
+	line    int
+	errors  ErrorList
+}
+
+func NewCompiler() *Compiler {
+	return &Compiler{line: 1}
+}
+
+// Compile returns the top-level script as a function of no arguments.
+func (c *Compiler) Compile(statements []Stmt) (*vmFunction, error) {
+	c.beginFunction(NONE, "")
+	for _, stmt := range statements {
+		c.compileStatement(stmt)
+	}
+	fn := c.endFunction()
+	return fn, c.errors.Err()
+}
+
+func (c *Compiler) compileStatement(stmt Stmt) {
+	stmt.Accept(c)
+}
+
+func (c *Compiler) compileExpr(expr Expr) {
+	expr.Accept(c)
+}
+
+func (c *Compiler) VisitGroupExpr(e *GroupExpr) interface{} {
+	c.compileExpr(e.expression)
+	return nil
+}
+
+func (c *Compiler) VisitBinaryExpr(e *BinaryExpr) interface{} {
+	c.compileExpr(e.left)
+	c.compileExpr(e.right)
+	c.line = e.operator.Line
+
+	switch e.operator.TokenType {
+	case PLUS:
+		c.emitOp(OpAdd)
+	case MINUS:
+		c.emitOp(OpSubtract)
+	case STAR:
+		c.emitOp(OpMultiply)
+	case SLASH:
+		c.emitOp(OpDivide)
+	case GREATER:
+		c.emitOp(OpGreater)
+	case GreaterEqual:
+		c.emitOp(OpGreaterEqual)
+	case LESS:
+		c.emitOp(OpLess)
+	case LessEqual:
+		c.emitOp(OpLessEqual)
+	case EqualEqual:
+		c.emitOp(OpEqual)
+	case BangEqual:
+		c.emitOps(OpEqual, OpNot)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitLogicalExpr(e *LogicalExpr) interface{} {
+	c.compileExpr(e.left)
+	c.line = e.operator.Line
+
+	if e.operator.TokenType == AND {
+		endJump := c.emitJump(OpJumpIfFalse)
+		c.emitOp(OpPop)
+		c.compileExpr(e.right)
+		c.patchJump(endJump)
+		return nil
+	}
+
+	elseJump := c.emitJump(OpJumpIfFalse)
+	endJump := c.emitJump(OpJump)
+	c.patchJump(elseJump)
+	c.emitOp(OpPop)
+	c.compileExpr(e.right)
+	c.patchJump(endJump)
+	return nil
+}
+
+func (c *Compiler) VisitLiteralExpr(e *LiteralExpr) interface{} {
+	switch e.value {
+	case nil:
+		c.emitOp(OpNil)
+	case true:
+		c.emitOp(OpTrue)
+	case false:
+		c.emitOp(OpFalse)
+	default:
+		c.emitConstant(e.value)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitUnaryExpr(e *UnaryExpr) interface{} {
+	c.compileExpr(e.right)
+	c.line = e.operator.Line
+
+	switch e.operator.TokenType {
+	case BANG:
+		c.emitOp(OpNot)
+	case MINUS:
+		c.emitOp(OpNegate)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitVariableExpr(e *VariableExpr) interface{} {
+	c.namedVariable(e.name, false)
+	return nil
+}
+
+func (c *Compiler) VisitAssignExpr(e *AssignExpr) interface{} {
+	c.compileExpr(e.value)
+	c.namedVariable(e.name, true)
+	return nil
+}
+
+func (c *Compiler) VisitCallExpr(e *CallExpr) interface{} {
+	switch callee := e.callee.(type) {
+	case *GetExpr:
+		c.compileExpr(callee.object)
+		c.compileArguments(e.arguments)
+		c.line = callee.name.Line
+		c.emitConstantOp(OpInvoke, c.identifierConstant(callee.name.Lexeme))
+		c.emitByte(byte(len(e.arguments)))
+	case *SuperExpr:
+		c.namedVariable(NewToken(THIS, "this", nil, callee.keyword.Line), false)
+		c.compileArguments(e.arguments)
+		c.namedVariable(NewToken(SUPER, "super", nil, callee.keyword.Line), false)
+		c.line = callee.method.Line
+		c.emitConstantOp(OpSuperInvoke, c.identifierConstant(callee.method.Lexeme))
+		c.emitByte(byte(len(e.arguments)))
+	default:
+		c.compileExpr(e.callee)
+		c.compileArguments(e.arguments)
+		c.line = e.paren.Line
+		c.emitOp(OpCall)
+		c.emitByte(byte(len(e.arguments)))
+	}
+	return nil
+}
+
+func (c *Compiler) compileArguments(arguments []Expr) {
+	for _, arg := range arguments {
+		c.compileExpr(arg)
+	}
+}
+
+func (c *Compiler) VisitGetExpr(e *GetExpr) interface{} {
+	c.compileExpr(e.object)
+	c.line = e.name.Line
+	c.emitConstantOp(OpGetProperty, c.identifierConstant(e.name.Lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitSetExpr(e *SetExpr) interface{} {
+	c.compileExpr(e.object)
+	c.compileExpr(e.value)
+	c.line = e.name.Line
+	c.emitConstantOp(OpSetProperty, c.identifierConstant(e.name.Lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitThisExpr(e *ThisExpr) interface{} {
+	c.namedVariable(e.keyword, false)
+	return nil
+}
+
+func (c *Compiler) VisitSuperExpr(e *SuperExpr) interface{} {
+	c.namedVariable(NewToken(THIS, "this", nil, e.keyword.Line), false)
+	c.namedVariable(e.keyword, false)
+	c.line = e.method.Line
+	c.emitConstantOp(OpGetSuper, c.identifierConstant(e.method.Lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitListExpr(e *ListExpr) interface{} {
+	c.compileArguments(e.elements)
+	c.line = e.bracket.Line
+	c.emitCount(OpList, len(e.elements), e.bracket)
+	return nil
+}
+
+func (c *Compiler) VisitMapExpr(e *MapExpr) interface{} {
+	for idx, key := range e.keys {
+		c.compileExpr(key)
+		c.compileExpr(e.values[idx])
+	}
+	c.line = e.brace.Line
+	c.emitCount(OpMap, len(e.keys), e.brace)
+	return nil
+}
+
+// emitCount emits op with the number of elements it collects from the stack.
+func (c *Compiler) emitCount(op OpCode, count int, token Token) {
+	if count > maxJump {
+		c.error(token, "Too many elements in one literal.")
+	}
+	c.emitOp(op)
+	c.emitShort(count)
+}
+
+func (c *Compiler) VisitIndexExpr(e *IndexExpr) interface{} {
+	c.compileExpr(e.object)
+	c.compileExpr(e.index)
+	c.line = e.bracket.Line
+	c.emitOp(OpGetIndex)
+	return nil
+}
+
+func (c *Compiler) VisitSetIndexExpr(e *SetIndexExpr) interface{} {
+	c.compileExpr(e.object)
+	c.compileExpr(e.index)
+	c.compileExpr(e.value)
+	c.line = e.bracket.Line
+	c.emitOp(OpSetIndex)
+	return nil
+}
+
+func (c *Compiler) VisitIfStmt(s *IfStmt) interface{} {
+	c.compileExpr(s.condition)
+	thenJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	c.compileStatement(s.thenBranch)
+	elseJump := c.emitJump(OpJump)
+
+	c.patchJump(thenJump)
+	c.emitOp(OpPop)
+	if s.elseBranch != nil {
+		c.compileStatement(s.elseBranch)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+func (c *Compiler) VisitBlockStmt(b *BlockStmt) interface{} {
+	c.beginScope()
+	for _, stmt := range b.statements {
+		c.compileStatement(stmt)
+	}
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) VisitVariableStmt(s *VariableStmt) interface{} {
+	c.line = s.name.Line
+	c.declareVariable(s.name)
+	if s.initializer != nil {
+		c.compileExpr(s.initializer)
+	} else {
+		c.emitOp(OpNil)
+	}
+	c.defineVariable(s.name)
+	return nil
+}
+
+// VisitImportStmt leaves the module on the stack for the alias to be
+// defined from, or has OpImportAll define its exports.
+func (c *Compiler) VisitImportStmt(s *ImportStmt) interface{} {
+	c.line = s.keyword.Line
+	c.emitConstantOp(OpImport, c.makeConstant(&vmImport{path: s.path.Literal.(string), importer: s.keyword.File}))
+	if s.alias != nil {
+		c.defineVariable(*s.alias)
+	} else {
+		c.emitOp(OpImportAll)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitWhileStmt(w *WhileStmt) interface{} {
+	loopStart := len(c.chunk().Code)
+	c.compileExpr(w.condition)
+
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	loop := c.beginLoop()
+	c.compileStatement(w.body)
+	c.patchJumps(loop.continues)
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.emitOp(OpPop)
+	c.endLoop()
+	return nil
+}
+
+func (c *Compiler) VisitBreakStmt(b *BreakStmt) interface{} {
+	c.line = b.keyword.Line
+	loop := c.current.loop
+	c.leaveTries(loop)
+	c.line = b.keyword.Line
+	c.discardLocals(loop.scopeDepth)
+	loop.breaks = append(loop.breaks, c.emitJump(OpJump))
+	return nil
+}
+
+func (c *Compiler) VisitContinueStmt(s *ContinueStmt) interface{} {
+	c.line = s.keyword.Line
+	loop := c.current.loop
+	c.leaveTries(loop)
+	c.line = s.keyword.Line
+	c.discardLocals(loop.scopeDepth)
+	loop.continues = append(loop.continues, c.emitJump(OpJump))
+	return nil
+}
+
+func (c *Compiler) VisitThrowStmt(s *ThrowStmt) interface{} {
+	c.compileExpr(s.value)
+	c.line = s.keyword.Line
+	c.emitOp(OpThrow)
+	return nil
+}
+
+// VisitTryStmt pushes a handler for the finally clause and, inside it, one
+// for the catch clause around the body. The VM jumps to a handler with what
+// it caught as the only local of a new scope: the thrown value for catch,
+// and for finally the exception itself, which OpRethrow raises again after
+// the clause. Leaving the body or catch clause any other way runs a copy of
+// the finally clause compiled in place.
+func (c *Compiler) VisitTryStmt(s *TryStmt) interface{} {
+	c.line = s.keyword.Line
+	t := &tryCompiler{
+		enclosing:  c.current.try,
+		finally:    s.finallyBody,
+		scopeDepth: c.current.scopeDepth,
+		loop:       c.current.loop,
+	}
+	c.current.try = t
+	finallyHandler := -1
+	if s.finallyBody != nil {
+		finallyHandler = c.emitJump(OpTryFinally)
+		t.handlers++
+	}
+	if s.catchBody == nil {
+		c.compileStatement(s.body)
+	} else {
+		catchHandler := c.emitJump(OpTryCatch)
+		t.handlers++
+		c.compileStatement(s.body)
+		c.emitOp(OpEndTry)
+		t.handlers--
+		skipJump := c.emitJump(OpJump)
+
+		c.patchJump(catchHandler)
+		c.beginScope()
+		c.addLocal(s.name)
+		c.markInitialized()
+		for _, stmt := range s.catchBody.statements {
+			c.compileStatement(stmt)
+		}
+		c.endScope()
+		c.patchJump(skipJump)
+	}
+	c.current.try = t.enclosing
+	if s.finallyBody == nil {
+		return nil
+	}
+
+	c.emitOp(OpEndTry)
+	c.compileStatement(s.finallyBody)
+	endJump := c.emitJump(OpJump)
+	c.patchJump(finallyHandler)
+	c.beginScope()
+	c.addLocal(NewToken(IDENTIFIER, "(exception)", nil, s.keyword.Line))
+	c.markInitialized()
+	c.compileStatement(s.finallyBody)
+	c.emitOp(OpRethrow)
+	c.dropScope()
+	c.patchJump(endJump)
+	return nil
+}
+
+// leaveTries compiles what a jump out of the try statements around it
+// does first, innermost statement first: pop their handlers and run their
+// finally clauses. A break or continue leaves the statements inside its
+// loop; a return, given a nil loop, leaves them all.
+func (c *Compiler) leaveTries(loop *loopCompiler) {
+	fc := c.current
+	for t := fc.try; t != nil && (loop == nil || t.loop == loop); t = t.enclosing {
+		for n := 0; n < t.handlers; n++ {
+			c.emitOp(OpEndTry)
+		}
+		if t.finally == nil {
+			continue
+		}
+		// The locals declared inside the statement are still on the stack,
+		// but the finally clause must not see them.
+		var names []string
+		for i := len(fc.locals) - 1; i >= 0 && fc.locals[i].depth > t.scopeDepth; i-- {
+			names = append(names, fc.locals[i].name)
+			fc.locals[i].name = ""
+		}
+		fc.try = t.enclosing
+		c.compileStatement(t.finally)
+		fc.try = t
+		for idx, name := range names {
+			fc.locals[len(fc.locals)-1-idx].name = name
+		}
+	}
+}
+
+// beginLoop starts collecting the jumps of a loop body about to be compiled
+// in the current scope.
+func (c *Compiler) beginLoop() *loopCompiler {
+	c.current.loop = &loopCompiler{enclosing: c.current.loop, scopeDepth: c.current.scopeDepth}
+	return c.current.loop
+}
+
+// endLoop sends the loop's break statements to the current position.
+func (c *Compiler) endLoop() {
+	c.patchJumps(c.current.loop.breaks)
+	c.current.loop = c.current.loop.enclosing
+}
+
+func (c *Compiler) patchJumps(offsets []int) {
+	for _, offset := range offsets {
+		c.patchJump(offset)
+	}
+}
+
+// discardLocals emits the pops for every local deeper than depth, without
+// forgetting them, for a jump out of their scopes.
+func (c *Compiler) discardLocals(depth int) {
+	locals := c.current.locals
+	for i := len(locals) - 1; i >= 0 && locals[i].depth > depth; i-- {
+		if locals[i].isCaptured {
+			c.emitOp(OpCloseUpvalue)
+		} else {
+			c.emitOp(OpPop)
+		}
+	}
+}
+
+func (c *Compiler) VisitForStmt(f *ForStmt) interface{} {
+	c.beginScope()
+	if f.initializer != nil {
+		c.compileStatement(f.initializer)
+	}
+
+	loopStart := len(c.chunk().Code)
+	exitJump := -1
+	if f.condition != nil {
+		c.compileExpr(f.condition)
+		exitJump = c.emitJump(OpJumpIfFalse)
+		c.emitOp(OpPop)
+	}
+
+	loop := c.beginLoop()
+	c.compileStatement(f.body)
+	c.patchJumps(loop.continues)
+	if f.increment != nil {
+		c.compileExpr(f.increment)
+		c.emitOp(OpPop)
+	}
+	c.emitLoop(loopStart)
+
+	if exitJump != -1 {
+		c.patchJump(exitJump)
+		c.emitOp(OpPop)
+	}
+	c.endLoop()
+	c.endScope()
+	return nil
+}
+
+// VisitForInStmt keeps the sequence and the next index in two hidden
+// locals. OpForIter pushes the next element as the loop variable, or jumps
+// past the loop when there is none; the variable gets a scope per iteration
+// so closures capture each element separately.
+func (c *Compiler) VisitForInStmt(f *ForInStmt) interface{} {
+	c.beginScope()
+	c.compileExpr(f.iterable)
+	c.line = f.name.Line
+	c.emitOp(OpIterable)
+	c.addLocal(NewToken(IDENTIFIER, "(sequence)", nil, f.name.Line))
+	c.markInitialized()
+	c.emitConstant(0.0)
+	c.addLocal(NewToken(IDENTIFIER, "(index)", nil, f.name.Line))
+	c.markInitialized()
+	sequence := len(c.current.locals) - 2
+
+	loopStart := len(c.chunk().Code)
+	c.line = f.name.Line
+	c.emitOp(OpForIter)
+	c.emitByte(byte(sequence))
+	c.emitShort(0xffff)
+	exitJump := len(c.chunk().Code) - 2
+
+	loop := c.beginLoop()
+	c.beginScope()
+	c.addLocal(f.name)
+	c.markInitialized()
+	c.compileStatement(f.body)
+	c.endScope()
+	c.patchJumps(loop.continues)
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.endLoop()
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) VisitFunctionStmt(f *FunctionStmt) interface{} {
+	c.line = f.name.Line
+	c.declareVariable(f.name)
+	// A local function may refer to itself, so it is usable before its body
+	// is compiled.
+	if c.current.scopeDepth > 0 {
+		c.markInitialized()
+	}
+	c.function(f, FUNCTION)
+	c.defineVariable(f.name)
+	return nil
+}
+
+func (c *Compiler) VisitFunctionExpr(f *FunctionExpr) interface{} {
+	c.line = f.keyword.Line
+	c.function(f.declaration, FUNCTION)
+	return nil
+}
+
+func (c *Compiler) function(f *FunctionStmt, ftype FunctionType) {
+	c.beginFunction(ftype, f.name.Lexeme)
+	c.current.function.anonymous = f.name.Lexeme == ""
+	c.beginScope()
+	for _, param := range f.params {
+		c.current.function.arity++
+		c.declareVariable(param)
+		c.defineVariable(param)
+	}
+	for _, stmt := range f.body {
+		c.compileStatement(stmt)
+	}
+
+	upvalues := c.current.upvalues
+	fn := c.endFunction()
+	c.emitConstantOp(OpClosure, c.makeConstant(fn))
+	for _, upvalue := range upvalues {
+		if upvalue.isLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(upvalue.index)
+	}
+}
+
+// VisitReturnStmt keeps the value in a hidden local while the finally
+// clauses of the try statements it leaves run.
+func (c *Compiler) VisitReturnStmt(r *ReturnStmt) interface{} {
+	c.line = r.keyword.Line
+	if r.value == nil {
+		c.emitReturnValue()
+	} else {
+		c.compileExpr(r.value)
+	}
+	if c.current.try == nil {
+		c.emitOp(OpReturn)
+		return nil
+	}
+	c.beginScope()
+	c.addLocal(NewToken(IDENTIFIER, "(return)", nil, r.keyword.Line))
+	c.markInitialized()
+	c.leaveTries(nil)
+	c.line = r.keyword.Line
+	c.emitOp(OpReturn)
+	c.dropScope()
+	return nil
+}
+
+func (c *Compiler) VisitExprStmt(e *ExprStmt) interface{} {
+	c.compileExpr(e.expression)
+	c.emitOp(OpPop)
+	return nil
+}
+
+func (c *Compiler) VisitPrintStmt(p *PrintStmt) interface{} {
+	c.compileExpr(p.expression)
+	c.emitOp(OpPrint)
+	return nil
+}
+
+func (c *Compiler) VisitClassStmt(s *ClassStmt) interface{} {
+	c.line = s.name.Line
+	nameConstant := c.identifierConstant(s.name.Lexeme)
+	c.declareVariable(s.name)
+	c.emitConstantOp(OpClass, nameConstant)
+	c.defineVariable(s.name)
+
+	c.class = &classCompiler{enclosing: c.class}
+
+	if s.superclass != nil {
+		c.namedVariable(s.superclass.name, false)
+		c.beginScope()
+		c.addLocal(NewToken(SUPER, "super", nil, s.superclass.name.Line))
+		c.markInitialized()
+
+		c.namedVariable(s.name, false)
+		c.emitOp(OpInherit)
+		c.class.hasSuperclass = true
+	}
+
+	c.namedVariable(s.name, false)
+	for _, method := range s.methods {
+		fn := method.(*FunctionStmt)
+		ftype := METHOD
+		if fn.name.Lexeme == "init" {
+			ftype = INITIALIZER
+		}
+		c.line = fn.name.Line
+		c.function(fn, ftype)
+		c.emitConstantOp(OpMethod, c.identifierConstant(fn.name.Lexeme))
+	}
+	c.emitOp(OpPop)
+
+	if c.class.hasSuperclass {
+		c.endScope()
+	}
+	c.class = c.class.enclosing
+	return nil
+}
+
+func (c *Compiler) beginFunction(ftype FunctionType, name string) {
+	fc := &funcCompiler{
+		enclosing: c.current,
+		function:  &vmFunction{name: name, chunk: NewChunk()},
+		ftype:     ftype,
+		names:     make(map[string]int),
+	}
+	// Slot zero holds the callee, or the receiver inside methods.
+	receiver := ""
+	if ftype == METHOD || ftype == INITIALIZER {
+		receiver = "this"
+	}
+	fc.locals = append(fc.locals, local{name: receiver})
+	c.current = fc
+}
+
+func (c *Compiler) endFunction() *vmFunction {
+	c.emitReturn()
+	fn := c.current.function
+	fn.upvalueCount = len(c.current.upvalues)
+	c.current = c.current.enclosing
+	return fn
+}
+
+func (c *Compiler) beginScope() {
+	c.current.scopeDepth++
+}
+
+// dropScope ends a scope whose code never reaches its end, as it returns
+// or throws, so its locals need no pops.
+func (c *Compiler) dropScope() {
+	fc := c.current
+	fc.scopeDepth--
+	for len(fc.locals) > 0 && fc.locals[len(fc.locals)-1].depth > fc.scopeDepth {
+		fc.locals = fc.locals[:len(fc.locals)-1]
+	}
+}
+
+func (c *Compiler) endScope() {
+	fc := c.current
+	fc.scopeDepth--
+	for len(fc.locals) > 0 && fc.locals[len(fc.locals)-1].depth > fc.scopeDepth {
+		if fc.locals[len(fc.locals)-1].isCaptured {
+			c.emitOp(OpCloseUpvalue)
+		} else {
+			c.emitOp(OpPop)
+		}
+		fc.locals = fc.locals[:len(fc.locals)-1]
+	}
+}
+
+func (c *Compiler) declareVariable(name Token) {
+	if c.current.scopeDepth == 0 {
+		return
+	}
+	c.addLocal(name)
+}
+
+func (c *Compiler) addLocal(name Token) {
+	if len(c.current.locals) == maxLocals {
+		c.error(name, "Too many local variables in function.")
+		return
+	}
+	c.current.locals = append(c.current.locals, local{name: name.Lexeme, depth: -1})
+}
+
+func (c *Compiler) markInitialized() {
+	fc := c.current
+	if fc.scopeDepth == 0 {
+		return
+	}
+	fc.locals[len(fc.locals)-1].depth = fc.scopeDepth
+}
+
+func (c *Compiler) defineVariable(name Token) {
+	if c.current.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.emitConstantOp(OpDefineGlobal, c.identifierConstant(name.Lexeme))
+}
+
+func (c *Compiler) namedVariable(name Token, assign bool) {
+	c.line = name.Line
+	getOp, setOp := OpGetGlobal, OpSetGlobal
+	arg := resolveLocalSlot(c.current, name.Lexeme)
+	if arg != -1 {
+		getOp, setOp = OpGetLocal, OpSetLocal
+	} else if arg = c.resolveUpvalue(c.current, name); arg != -1 {
+		getOp, setOp = OpGetUpvalue, OpSetUpvalue
+	} else {
+		op := getOp
+		if assign {
+			op = setOp
+		}
+		c.emitConstantOp(op, c.identifierConstant(name.Lexeme))
+		return
+	}
+
+	if assign {
+		c.emitOp(setOp)
+	} else {
+		c.emitOp(getOp)
+	}
+	c.emitByte(byte(arg))
+}
+
+func resolveLocalSlot(fc *funcCompiler, name string) int {
+	for i := len(fc.locals) - 1; i >= 0; i-- {
+		if fc.locals[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Compiler) resolveUpvalue(fc *funcCompiler, name Token) int {
+	if fc.enclosing == nil {
+		return -1
+	}
+
+	if slot := resolveLocalSlot(fc.enclosing, name.Lexeme); slot != -1 {
+		fc.enclosing.locals[slot].isCaptured = true
+		return c.addUpvalue(fc, name, byte(slot), true)
+	}
+
+	if index := c.resolveUpvalue(fc.enclosing, name); index != -1 {
+		return c.addUpvalue(fc, name, byte(index), false)
+	}
+	return -1
+}
+
+func (c *Compiler) addUpvalue(fc *funcCompiler, name Token, index byte, isLocal bool) int {
+	for i, upvalue := range fc.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return i
+		}
+	}
+
+	if len(fc.upvalues) == maxUpvalues {
+		c.error(name, "Too many closure variables in function.")
+		return 0
+	}
+	fc.upvalues = append(fc.upvalues, upvalueRef{index: index, isLocal: isLocal})
+	return len(fc.upvalues) - 1
+}
+
+func (c *Compiler) chunk() *Chunk {
+	return c.current.function.chunk
+}
+
+func (c *Compiler) emitByte(b byte) {
+	c.chunk().write(b, c.line)
+}
+
+func (c *Compiler) emitOp(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *Compiler) emitOps(ops ...OpCode) {
+	for _, op := range ops {
+		c.emitOp(op)
+	}
+}
+
+func (c *Compiler) emitShort(value int) {
+	c.emitByte(byte(value >> 8))
+	c.emitByte(byte(value))
+}
+
+func (c *Compiler) emitConstantOp(op OpCode, constant int) {
+	c.emitOp(op)
+	c.emitShort(constant)
+}
+
+func (c *Compiler) emitConstant(value Value) {
+	c.emitConstantOp(OpConstant, c.makeConstant(value))
+}
+
+func (c *Compiler) emitReturn() {
+	c.emitReturnValue()
+	c.emitOp(OpReturn)
+}
+
+// emitReturnValue pushes what a function returns without a return value:
+// the instance for an initializer, nil otherwise.
+func (c *Compiler) emitReturnValue() {
+	if c.current.ftype == INITIALIZER {
+		c.emitOp(OpGetLocal)
+		c.emitByte(0)
+	} else {
+		c.emitOp(OpNil)
+	}
+}
+
+func (c *Compiler) emitJump(op OpCode) int {
+	c.emitOp(op)
+	c.emitShort(0xffff)
+	return len(c.chunk().Code) - 2
+}
+
+func (c *Compiler) patchJump(offset int) {
+	jump := len(c.chunk().Code) - offset - 2
+	if jump > maxJump {
+		c.error(NewToken(EOF, "", nil, c.line), "Too much code to jump over.")
+	}
+	c.chunk().Code[offset] = byte(jump >> 8)
+	c.chunk().Code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) emitLoop(loopStart int) {
+	c.emitOp(OpLoop)
+	offset := len(c.chunk().Code) - loopStart + 2
+	if offset > maxJump {
+		c.error(NewToken(EOF, "", nil, c.line), "Loop body too large.")
+	}
+	c.emitShort(offset)
+}
+
+func (c *Compiler) makeConstant(value Value) int {
+	constant := c.chunk().addConstant(value)
+	if constant >= maxConstants {
+		c.error(NewToken(EOF, "", nil, c.line), "Too many constants in one chunk.")
+		return 0
+	}
+	return constant
+}
+
+// identifierConstant interns name in the current chunk, so a global or
+// property used many times costs one constant slot.
+func (c *Compiler) identifierConstant(name string) int {
+	if constant, ok := c.current.names[name]; ok {
+		return constant
+	}
+	constant := c.makeConstant(name)
+	c.current.names[name] = constant
+	return constant
+}
+
+func (c *Compiler) error(token Token, msg string) {
+	c.errors = append(c.errors, &CompileError{Token: token, Line: token.Line, Message: msg})
+}
@@ -1,58 +1,67 @@
 package lox
 
+// LoxEnvironment holds the variables of one scope. The global environment
+// looks variables up by name, since globals may be declared after the code
+// that uses them is resolved. Every other environment is a frame of slots
+// that the LoxResolver numbers in declaration order, so locals are read and
+// written by index.
+//
+// globals is the global environment at the root of the chain: that of the
+// program, or of the module the code creating the frame belongs to.
 type LoxEnvironment struct {
-	values map[string]interface{}
-	parent *LoxEnvironment
+	values  map[string]interface{}
+	slots   []interface{}
+	parent  *LoxEnvironment
+	globals *LoxEnvironment
 }
 
+// NewLoxEnvironment returns a global environment.
 func NewLoxEnvironment() *LoxEnvironment {
 	env := &LoxEnvironment{}
 	env.values = make(map[string]interface{})
+	env.globals = env
 	return env
 }
 
+// NewLoxEnvironmentWithParent returns a local frame nested in parent.
 func NewLoxEnvironmentWithParent(parent *LoxEnvironment) *LoxEnvironment {
 	env := &LoxEnvironment{}
-	env.values = make(map[string]interface{})
 	env.parent = parent
+	env.globals = parent.globals
 	return env
 }
 
+// Define binds name in the global environment, or takes the next slot of a
+// local frame, where only the order of definitions matters.
 func (e *LoxEnvironment) Define(name string, value interface{}) {
-	e.values[name] = value
+	if e.values != nil {
+		e.values[name] = value
+	} else {
+		e.slots = append(e.slots, value)
+	}
 }
 
 func (e *LoxEnvironment) Get(name string) (interface{}, bool) {
 	value, ok := e.values[name]
-
-	if !ok && e.parent != nil {
-		return e.parent.Get(name)
-	}
 	return value, ok
 }
 
-func (e *LoxEnvironment) GetAt(dist int, name string) (interface{}, bool) {
-	value, ok := e.values[name]
-
-	if !ok && e.parent != nil {
-		return e.parent.Get(name)
-	}
-	return value, ok
+func (e *LoxEnvironment) GetAt(dist int, slot int) interface{} {
+	return e.ancestor(dist).slots[slot]
 }
 
-func (e *LoxEnvironment) Assign(name string, value interface{}) {
-	value, ok := e.values[name]
-
-	if !ok && e.parent != nil {
-		e.parent.Assign(name, value)
-	} else {
+// Assign updates an existing global. It reports false when name is not
+// defined.
+func (e *LoxEnvironment) Assign(name string, value interface{}) bool {
+	if _, ok := e.values[name]; ok {
 		e.values[name] = value
+		return true
 	}
-
+	return false
 }
 
-func (e *LoxEnvironment) AssignAt(dist int, name Token, value interface{}) {
-	e.ancestor(dist).values[name.Lexeme] = value
+func (e *LoxEnvironment) AssignAt(dist int, slot int, value interface{}) {
+	e.ancestor(dist).slots[slot] = value
 }
 
 func (e *LoxEnvironment) ancestor(dist int) *LoxEnvironment {
@@ -0,0 +1,114 @@
+package lox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScanError reports a character sequence the Scanner could not turn into a token.
+type ScanError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// ParseError reports a token the Parser did not expect.
+type ParseError struct {
+	Token   Token
+	Line    int
+	Message string
+}
+
+// ResolveError reports a static error found by the LoxResolver, such as
+// returning from top-level code or reading a local in its own initializer.
+type ResolveError struct {
+	Token   Token
+	Line    int
+	Message string
+}
+
+// CompileError reports a program that exceeds a limit of the bytecode
+// format, such as too many locals in one function.
+type CompileError struct {
+	Token   Token
+	Line    int
+	Message string
+}
+
+// RuntimeError reports a fault raised while the Interpreter or the VM
+// executes a program. Token is the token the fault is attributed to; the VM
+// only knows the line, so it leaves Token zero. Stack is the stack trace at
+// the fault, innermost frame first, like "[line 3] in area()".
+type RuntimeError struct {
+	Token   Token
+	Line    int
+	Message string
+	Stack   []string
+}
+
+// Warning reports a suspicious construct found by a LoxResolver in lint
+// mode. Check names the kind of problem, such as "unused" or "shadow".
+type Warning struct {
+	Token   Token
+	Line    int
+	Column  int
+	Check   string
+	Message string
+}
+
+// ErrorList collects the errors of a stage that keeps going after the first one.
+type ErrorList []error
+
+func NewRuntimeError(token Token, msg string) *RuntimeError {
+	return &RuntimeError{Token: token, Line: token.Line, Message: msg}
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("[line %d] Error: %s", e.Line, e.Message)
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("[line %d] Error%s: %s", e.Line, location(e.Token), e.Message)
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("[line %d] Error%s: %s", e.Line, location(e.Token), e.Message)
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("[line %d] Error: %s", e.Line, e.Message)
+}
+
+func (e *RuntimeError) Error() string {
+	if len(e.Stack) == 0 {
+		return fmt.Sprintf("%s\n[line %d]", e.Message, e.Line)
+	}
+	return e.Message + "\n" + strings.Join(e.Stack, "\n")
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("[line %d:%d] Warning%s: %s", w.Line, w.Column, location(w.Token), w.Message)
+}
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for idx, err := range l {
+		msgs[idx] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Err returns the list as an error, or nil when it is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+func location(token Token) string {
+	if token.TokenType == EOF {
+		return " at end"
+	}
+	return " at '" + token.Lexeme + "'"
+}
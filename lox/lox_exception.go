@@ -0,0 +1,106 @@
+package lox
+
+import (
+	"fmt"
+)
+
+// LoxError is an error instance: what a catch clause binds for a runtime
+// error, and what the Error native makes for scripts to throw. Its message,
+// line and stack are read as properties. stack lists the frames that were
+// active, innermost first, like "[line 3] in area()".
+type LoxError struct {
+	message string
+	line    int
+	stack   []string
+}
+
+// Get returns the property called name.
+func (e *LoxError) Get(name string) (Value, bool) {
+	switch name {
+	case "message":
+		return e.message, true
+	case "line":
+		return float64(e.line), true
+	case "stack":
+		stack := make([]Value, len(e.stack))
+		for idx, frame := range e.stack {
+			stack[idx] = frame
+		}
+		return NewLoxList(stack), true
+	}
+	return nil, false
+}
+
+func (e *LoxError) String() string {
+	return "Error: " + e.message
+}
+
+// newError is the Error native. The line and stack of the error are filled
+// in when it is first thrown.
+func newError(args []Value) (Value, error) {
+	message, err := stringArg("Error", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &LoxError{message: message}, nil
+}
+
+// thrownValue carries a thrown value to the try statement that handles it.
+// The Interpreter panics with it and the VM returns it as an error. A
+// runtime error becomes one holding a *LoxError once a try statement sees
+// it. line is the line of the throw statement and stack the stack trace
+// there, which an error instance keeps itself.
+type thrownValue struct {
+	value Value
+	line  int
+	stack []string
+}
+
+func (t *thrownValue) Error() string {
+	return t.runtimeError().Error()
+}
+
+// runtimeError is the error a value no try statement caught stops the
+// program with. An uncaught error instance reads like the runtime error it
+// may have started out as.
+func (t *thrownValue) runtimeError() *RuntimeError {
+	if e, ok := t.value.(*LoxError); ok {
+		return &RuntimeError{Line: e.line, Message: e.message, Stack: e.stack}
+	}
+	return &RuntimeError{Line: t.line, Message: "Uncaught " + stringify(t.value) + ".", Stack: t.stack}
+}
+
+// throw fills in where an error instance was thrown from, unless it was
+// thrown before, and returns the thrownValue for value.
+func throw(value Value, line int, stack func(line int) []string) *thrownValue {
+	e, ok := value.(*LoxError)
+	if !ok {
+		return &thrownValue{value: value, line: line, stack: stack(line)}
+	}
+	if e.line == 0 {
+		e.line = line
+		e.stack = stack(line)
+	}
+	return &thrownValue{value: value, line: line}
+}
+
+// traceEntry formats one frame of a stack trace: the line it is executing
+// and its function, which is "script" for top-level code.
+func traceEntry(function string, anonymous bool, line int) string {
+	switch {
+	case anonymous:
+		function = "<fn>"
+	case function == "":
+		function = "script"
+	default:
+		function += "()"
+	}
+	return fmt.Sprintf("[line %d] in %s", line, function)
+}
+
+// raised is the thrownValue for a runtime error: an error instance with the
+// message, line and stack trace of err.
+func raised(err *RuntimeError) *thrownValue {
+	value := &LoxError{message: err.Message, line: err.Line, stack: err.Stack}
+	return &thrownValue{value: value, line: err.Line}
+}
@@ -2,64 +2,121 @@ package lox
 
 type Expr interface {
 	Accept(p Visitor) interface{}
+	Span() Span
 }
 
 type BinaryExpr struct {
+	node
 	left     Expr
 	operator Token
 	right    Expr
 }
 
 type AssignExpr struct {
+	node
 	name  Token
 	value Expr
 }
 
 type CallExpr struct {
+	node
 	callee    Expr
 	paren     Token
 	arguments []Expr
 }
 
 type GetExpr struct {
+	node
 	object Expr
 	name   Token
 }
 
 type GroupExpr struct {
+	node
 	expression Expr
 }
 
 type LiteralExpr struct {
+	node
 	value interface{}
 }
 
 type VariableExpr struct {
+	node
 	name Token
 }
 
 type LogicalExpr struct {
+	node
 	left     Expr
 	operator Token
 	right    Expr
 }
 
 type SetExpr struct {
+	node
 	object Expr
 	name   Token
 	value  Expr
 }
 
 type SuperExpr struct {
+	node
 	keyword Token
 	method  Token
 }
 
 type ThisExpr struct {
+	node
 	keyword Token
 }
 
+// ListExpr is a list literal, [a, b, c].
+type ListExpr struct {
+	node
+	bracket  Token
+	elements []Expr
+}
+
+// MapExpr is a map literal, {k: v, ...}. keys and values are parallel.
+type MapExpr struct {
+	node
+	brace  Token
+	keys   []Expr
+	values []Expr
+}
+
+// IndexExpr reads an element, object[index].
+type IndexExpr struct {
+	node
+	object  Expr
+	bracket Token
+	index   Expr
+}
+
+// SetIndexExpr assigns an element, object[index] = value.
+type SetIndexExpr struct {
+	node
+	object  Expr
+	bracket Token
+	index   Expr
+	value   Expr
+}
+
+// FunctionExpr is an anonymous function: fun (a, b) { ... }, or the arrow
+// forms (a, b) => { ... } and a => expression. declaration has an empty
+// name, so both backends treat it like any other function. The body of a
+// concise arrow is a single return statement of its expression.
+type FunctionExpr struct {
+	node
+	keyword     Token
+	declaration *FunctionStmt
+	arrow       bool
+	concise     bool
+}
+
 type UnaryExpr struct {
+	node
 	operator Token
 	right    Expr
 }
@@ -140,6 +197,57 @@ func NewSuperExpr(keyword Token, method Token) Expr {
 	}
 }
 
+func NewListExpr(bracket Token, elements []Expr) Expr {
+	return &ListExpr{
+		bracket:  bracket,
+		elements: elements,
+	}
+}
+
+func NewMapExpr(brace Token, keys []Expr, values []Expr) Expr {
+	return &MapExpr{
+		brace:  brace,
+		keys:   keys,
+		values: values,
+	}
+}
+
+func NewIndexExpr(object Expr, bracket Token, index Expr) Expr {
+	return &IndexExpr{
+		object:  object,
+		bracket: bracket,
+		index:   index,
+	}
+}
+
+func NewSetIndexExpr(object Expr, bracket Token, index Expr, value Expr) Expr {
+	return &SetIndexExpr{
+		object:  object,
+		bracket: bracket,
+		index:   index,
+		value:   value,
+	}
+}
+
+// NewFunctionExpr wraps params and body in a declaration named after
+// keyword, with an empty lexeme.
+func NewFunctionExpr(keyword Token, params []Token, body []Stmt, arrow bool, concise bool) Expr {
+	name := keyword
+	name.TokenType = IDENTIFIER
+	name.Lexeme = ""
+	return &FunctionExpr{
+		keyword:     keyword,
+		declaration: NewFunctionStmt(name, params, body).(*FunctionStmt),
+		arrow:       arrow,
+		concise:     concise,
+	}
+}
+
+func (e *FunctionExpr) setSpan(span Span) {
+	e.span = span
+	e.declaration.setSpan(span)
+}
+
 func (e *UnaryExpr) Accept(p Visitor) interface{} {
 	return p.VisitUnaryExpr(e)
 }
@@ -181,9 +289,29 @@ func (t *ThisExpr) Accept(p Visitor) interface{} {
 }
 
 func (b *CallExpr) Accept(p Visitor) interface{} {
-	panic("not implemented")
+	return p.VisitCallExpr(b)
+}
+
+func (s *SuperExpr) Accept(p Visitor) interface{} {
+	return p.VisitSuperExpr(s)
+}
+
+func (l *ListExpr) Accept(p Visitor) interface{} {
+	return p.VisitListExpr(l)
+}
+
+func (m *MapExpr) Accept(p Visitor) interface{} {
+	return p.VisitMapExpr(m)
+}
+
+func (i *IndexExpr) Accept(p Visitor) interface{} {
+	return p.VisitIndexExpr(i)
+}
+
+func (s *SetIndexExpr) Accept(p Visitor) interface{} {
+	return p.VisitSetIndexExpr(s)
 }
 
-func (s SuperExpr) Accept(p Visitor) interface{} {
-	panic("implement me")
+func (f *FunctionExpr) Accept(p Visitor) interface{} {
+	return p.VisitFunctionExpr(f)
 }
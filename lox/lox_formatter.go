@@ -0,0 +1,491 @@
+package lox
+
+import (
+	"strings"
+)
+
+// indentUnit is the indentation of one level of nesting.
+const indentUnit = "  "
+
+// Format returns source in canonical Lox style: two-space indentation, one
+// statement per line, single spaces around binary operators and at most one
+// blank line between statements. Comments are kept; a comment that shares a
+// line with code stays at the end of that line, separated by two spaces.
+// Literals are written exactly as they appear in source.
+//
+// Source that does not scan or parse is returned unchanged with the error.
+func Format(source string) (string, error) {
+	scanner := NewScanner()
+	if err := scanner.Eval(source); err != nil {
+		return source, err
+	}
+	statements, err := NewParser(scanner.Tokens).Parse()
+	if err != nil {
+		return source, err
+	}
+
+	f := &Formatter{source: source, comments: scanner.Comments}
+	f.statements(statements, len(source))
+	f.flushComments(len(source))
+	return f.String(), nil
+}
+
+// Formatter writes an AST back out as Lox source. Expressions are visited
+// for their text; statements append lines.
+type Formatter struct {
+	source   string
+	comments []Token
+	lines    []formattedLine
+	indent   int
+
+	// inline makes the next statement continue the last line, as the body
+	// of an if or a loop that is not a block does.
+	inline bool
+	// lastLine is the source line of the code written last, so comments on
+	// the same line can follow it. opened is set right after a "{".
+	lastLine int
+	opened   bool
+}
+
+type formattedLine struct {
+	indent  int
+	text    string
+	comment string
+}
+
+func (f *Formatter) String() string {
+	var out strings.Builder
+	for _, line := range f.lines {
+		if line.text == "" && line.comment == "" {
+			out.WriteString("\n")
+			continue
+		}
+		out.WriteString(strings.Repeat(indentUnit, line.indent))
+		out.WriteString(line.text)
+		if line.comment != "" {
+			if line.text != "" {
+				out.WriteString("  ")
+			}
+			out.WriteString(line.comment)
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// statements writes a statement list whose enclosing construct ends at
+// offset end. Comments before end that no statement claimed are written
+// after the last statement.
+func (f *Formatter) statements(statements []Stmt, end int) {
+	for _, stmt := range statements {
+		f.statement(stmt)
+	}
+	f.flushComments(end)
+}
+
+// statement writes stmt with the comments before it, keeping a blank line
+// that separated it from the previous statement.
+func (f *Formatter) statement(stmt Stmt) {
+	span := stmt.Span()
+	f.flushComments(span.Start.Offset)
+	f.separate(span.Start.Line)
+	f.lastLine = span.Start.Line
+	stmt.Accept(f)
+	f.lastLine = span.End.Line
+}
+
+// flushComments writes the comments that start before offset. One on the
+// line of the last code written trails that code; the rest get lines of
+// their own.
+func (f *Formatter) flushComments(offset int) {
+	for len(f.comments) > 0 && f.comments[0].Offset < offset {
+		comment := f.comments[0]
+		f.comments = f.comments[1:]
+		if comment.Line == f.lastLine && len(f.lines) > 0 {
+			last := &f.lines[len(f.lines)-1]
+			if last.comment != "" {
+				last.comment += " "
+			}
+			last.comment += comment.Lexeme
+			continue
+		}
+		f.inline = false
+		f.separate(comment.Line)
+		f.lines = append(f.lines, formattedLine{indent: f.indent, comment: comment.Lexeme})
+		f.lastLine = comment.Line
+	}
+}
+
+// separate writes a blank line when the source had one before line, except
+// at the start of a file or block.
+func (f *Formatter) separate(line int) {
+	if f.inline || f.opened || len(f.lines) == 0 {
+		f.opened = false
+		return
+	}
+	if line > f.lastLine+1 {
+		f.lines = append(f.lines, formattedLine{})
+	}
+}
+
+// emit writes text on a new line, or continues the last line when a
+// statement is written inline. Text spanning several lines, as a function
+// expression with a block body does, has each further line indented to the
+// current level.
+func (f *Formatter) emit(text string) {
+	f.opened = false
+	lines := strings.Split(text, "\n")
+	if f.inline && len(f.lines) > 0 {
+		f.lines[len(f.lines)-1].text += " " + lines[0]
+	} else {
+		f.lines = append(f.lines, formattedLine{indent: f.indent, text: lines[0]})
+	}
+	f.inline = false
+	for _, line := range lines[1:] {
+		f.lines = append(f.lines, formattedLine{indent: f.indent, text: line})
+	}
+}
+
+// block writes a braced statement list after header, on the header's line.
+// The closing brace is left as the last line so a caller can continue it,
+// as "} else" does.
+func (f *Formatter) block(header string, statements []Stmt, span Span) {
+	closing := span.End.Offset - 1
+	if len(statements) == 0 && !f.commentsBefore(closing) {
+		f.emit(strings.TrimPrefix(header+" {}", " "))
+		return
+	}
+	f.emit(strings.TrimPrefix(header+" {", " "))
+	f.lastLine = span.Start.Line
+	f.opened = true
+	f.indent++
+	f.statements(statements, closing)
+	f.indent--
+	f.opened = false
+	f.emit("}")
+}
+
+func (f *Formatter) commentsBefore(offset int) bool {
+	return len(f.comments) > 0 && f.comments[0].Offset < offset
+}
+
+// body writes the body of an if or a loop: a block on the same line as its
+// header, any other statement inline after it.
+func (f *Formatter) body(header string, stmt Stmt) {
+	if block, ok := stmt.(*BlockStmt); ok {
+		f.block(header, block.statements, block.Span())
+		return
+	}
+	f.emit(header)
+	f.inline = true
+	f.statement(stmt)
+}
+
+// expr returns the text of e. Comments before e that no statement claimed
+// were written inside the expression containing it: they end the line
+// there, and e goes on the next line, indented one level further. A comment
+// after the last operand, before a closing bracket, is not inside any
+// operand and ends up after the statement.
+func (f *Formatter) expr(e Expr) string {
+	var comments []string
+	for f.commentsBefore(e.Span().Start.Offset) {
+		comments = append(comments, f.comments[0].Lexeme)
+		f.comments = f.comments[1:]
+	}
+	text := e.Accept(f).(string)
+	if len(comments) == 0 {
+		return text
+	}
+	return " " + strings.Join(comments, "\n"+indentUnit) + "\n" + indentUnit + text
+}
+
+// text returns the source of a node exactly as written.
+func (f *Formatter) text(span Span) string {
+	return f.source[span.Start.Offset:span.End.Offset]
+}
+
+func (f *Formatter) VisitExprStmt(e *ExprStmt) interface{} {
+	f.emit(f.expr(e.expression) + ";")
+	return nil
+}
+
+func (f *Formatter) VisitPrintStmt(p *PrintStmt) interface{} {
+	f.emit("print " + f.expr(p.expression) + ";")
+	return nil
+}
+
+func (f *Formatter) VisitVariableStmt(s *VariableStmt) interface{} {
+	if s.initializer == nil {
+		f.emit("var " + s.name.Lexeme + ";")
+	} else {
+		f.emit("var " + s.name.Lexeme + " = " + f.expr(s.initializer) + ";")
+	}
+	return nil
+}
+
+func (f *Formatter) VisitReturnStmt(r *ReturnStmt) interface{} {
+	if r.value == nil {
+		f.emit("return;")
+	} else {
+		f.emit("return " + f.expr(r.value) + ";")
+	}
+	return nil
+}
+
+func (f *Formatter) VisitBreakStmt(b *BreakStmt) interface{} {
+	f.emit("break;")
+	return nil
+}
+
+func (f *Formatter) VisitContinueStmt(c *ContinueStmt) interface{} {
+	f.emit("continue;")
+	return nil
+}
+
+func (f *Formatter) VisitImportStmt(s *ImportStmt) interface{} {
+	if s.alias == nil {
+		f.emit("import " + f.text(s.path.Span()) + ";")
+	} else {
+		f.emit("import " + f.text(s.path.Span()) + " as " + s.alias.Lexeme + ";")
+	}
+	return nil
+}
+
+func (f *Formatter) VisitBlockStmt(b *BlockStmt) interface{} {
+	f.block("", b.statements, b.Span())
+	return nil
+}
+
+func (f *Formatter) VisitIfStmt(i *IfStmt) interface{} {
+	f.body("if ("+f.expr(i.condition)+")", i.thenBranch)
+	if i.elseBranch == nil {
+		return nil
+	}
+	last := f.lines[len(f.lines)-1]
+	if _, ok := i.thenBranch.(*BlockStmt); !ok || last.comment != "" {
+		f.lastLine = i.thenBranch.Span().End.Line
+		f.emit("else")
+	} else {
+		f.lines[len(f.lines)-1].text += " else"
+	}
+	if _, ok := i.elseBranch.(*BlockStmt); ok {
+		f.inline = true
+		f.body("", i.elseBranch)
+		return nil
+	}
+	f.inline = true
+	f.statement(i.elseBranch)
+	return nil
+}
+
+// VisitTryStmt writes each clause after the closing brace of the block
+// before it, as "} else" is written, unless a comment ends that line.
+func (f *Formatter) VisitTryStmt(t *TryStmt) interface{} {
+	f.block("try", t.body.statements, t.body.Span())
+	last := t.body
+	if t.catchBody != nil {
+		f.clause("catch ("+t.name.Lexeme+")", last, t.catchBody)
+		last = t.catchBody
+	}
+	if t.finallyBody != nil {
+		f.clause("finally", last, t.finallyBody)
+	}
+	return nil
+}
+
+func (f *Formatter) clause(header string, previous *BlockStmt, block *BlockStmt) {
+	f.lastLine = previous.Span().End.Line
+	f.flushComments(block.Span().Start.Offset)
+	if f.lines[len(f.lines)-1].comment == "" {
+		f.inline = true
+	}
+	f.block(header, block.statements, block.Span())
+}
+
+func (f *Formatter) VisitThrowStmt(t *ThrowStmt) interface{} {
+	f.emit("throw " + f.expr(t.value) + ";")
+	return nil
+}
+
+func (f *Formatter) VisitWhileStmt(w *WhileStmt) interface{} {
+	f.body("while ("+f.expr(w.condition)+")", w.body)
+	return nil
+}
+
+func (f *Formatter) VisitForStmt(s *ForStmt) interface{} {
+	header := "for ("
+	switch init := s.initializer.(type) {
+	case nil:
+		header += ";"
+	case *VariableStmt:
+		header += "var " + init.name.Lexeme
+		if init.initializer != nil {
+			header += " = " + f.expr(init.initializer)
+		}
+		header += ";"
+	case *ExprStmt:
+		header += f.expr(init.expression) + ";"
+	}
+	if s.condition != nil {
+		header += " " + f.expr(s.condition)
+	}
+	header += ";"
+	if s.increment != nil {
+		header += " " + f.expr(s.increment)
+	}
+	f.body(header+")", s.body)
+	return nil
+}
+
+func (f *Formatter) VisitForInStmt(s *ForInStmt) interface{} {
+	f.body("for (var "+s.name.Lexeme+" in "+f.expr(s.iterable)+")", s.body)
+	return nil
+}
+
+func (f *Formatter) VisitFunctionStmt(fn *FunctionStmt) interface{} {
+	f.function("fun ", fn)
+	return nil
+}
+
+func (f *Formatter) function(keyword string, fn *FunctionStmt) {
+	params := make([]string, len(fn.params))
+	for idx, param := range fn.params {
+		params[idx] = param.Lexeme
+	}
+	f.block(keyword+fn.name.Lexeme+"("+strings.Join(params, ", ")+")", fn.body, fn.Span())
+}
+
+func (f *Formatter) VisitClassStmt(c *ClassStmt) interface{} {
+	header := "class " + c.name.Lexeme
+	if c.superclass != nil {
+		header += " < " + c.superclass.name.Lexeme
+	}
+	span := c.Span()
+	closing := span.End.Offset - 1
+	if len(c.methods) == 0 && !f.commentsBefore(closing) {
+		f.emit(header + " {}")
+		return nil
+	}
+	f.emit(header + " {")
+	f.lastLine = span.Start.Line
+	f.opened = true
+	f.indent++
+	for _, method := range c.methods {
+		fn := method.(*FunctionStmt)
+		f.flushComments(fn.Span().Start.Offset)
+		f.separate(fn.Span().Start.Line)
+		f.function("", fn)
+		f.lastLine = fn.Span().End.Line
+	}
+	f.flushComments(closing)
+	f.indent--
+	f.opened = false
+	f.emit("}")
+	return nil
+}
+
+func (f *Formatter) VisitAssignExpr(a *AssignExpr) interface{} {
+	return a.name.Lexeme + " = " + f.expr(a.value)
+}
+
+func (f *Formatter) VisitBinaryExpr(b *BinaryExpr) interface{} {
+	return f.expr(b.left) + " " + b.operator.Lexeme + " " + f.expr(b.right)
+}
+
+func (f *Formatter) VisitLogicalExpr(l *LogicalExpr) interface{} {
+	return f.expr(l.left) + " " + l.operator.Lexeme + " " + f.expr(l.right)
+}
+
+func (f *Formatter) VisitUnaryExpr(u *UnaryExpr) interface{} {
+	return u.operator.Lexeme + f.expr(u.right)
+}
+
+func (f *Formatter) VisitGroupExpr(g *GroupExpr) interface{} {
+	return "(" + f.expr(g.expression) + ")"
+}
+
+func (f *Formatter) VisitLiteralExpr(l *LiteralExpr) interface{} {
+	return f.text(l.Span())
+}
+
+func (f *Formatter) VisitVariableExpr(v *VariableExpr) interface{} {
+	return v.name.Lexeme
+}
+
+func (f *Formatter) VisitCallExpr(c *CallExpr) interface{} {
+	return f.expr(c.callee) + "(" + strings.Join(f.exprs(c.arguments), ", ") + ")"
+}
+
+func (f *Formatter) VisitGetExpr(g *GetExpr) interface{} {
+	return f.expr(g.object) + "." + g.name.Lexeme
+}
+
+func (f *Formatter) VisitSetExpr(s *SetExpr) interface{} {
+	return f.expr(s.object) + "." + s.name.Lexeme + " = " + f.expr(s.value)
+}
+
+func (f *Formatter) VisitThisExpr(t *ThisExpr) interface{} {
+	return "this"
+}
+
+func (f *Formatter) VisitSuperExpr(s *SuperExpr) interface{} {
+	return "super." + s.method.Lexeme
+}
+
+func (f *Formatter) VisitListExpr(l *ListExpr) interface{} {
+	return "[" + strings.Join(f.exprs(l.elements), ", ") + "]"
+}
+
+func (f *Formatter) VisitMapExpr(m *MapExpr) interface{} {
+	entries := make([]string, len(m.keys))
+	for idx, key := range m.keys {
+		entries[idx] = f.expr(key) + ": " + f.expr(m.values[idx])
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+func (f *Formatter) VisitIndexExpr(i *IndexExpr) interface{} {
+	return f.expr(i.object) + "[" + f.expr(i.index) + "]"
+}
+
+func (f *Formatter) VisitSetIndexExpr(s *SetIndexExpr) interface{} {
+	return f.expr(s.object) + "[" + f.expr(s.index) + "] = " + f.expr(s.value)
+}
+
+// VisitFunctionExpr formats a block body with a Formatter of its own and
+// returns it as several lines, which emit indents along with the statement
+// containing the function.
+func (f *Formatter) VisitFunctionExpr(e *FunctionExpr) interface{} {
+	fn := e.declaration
+	params := make([]string, len(fn.params))
+	for idx, param := range fn.params {
+		params[idx] = param.Lexeme
+	}
+	header := "fun (" + strings.Join(params, ", ") + ")"
+	if e.arrow && e.keyword.TokenType == IDENTIFIER {
+		header = params[0] + " =>"
+	} else if e.arrow {
+		header = "(" + strings.Join(params, ", ") + ") =>"
+	}
+	if e.concise {
+		return header + " " + f.expr(fn.body[0].(*ReturnStmt).value)
+	}
+	span := e.Span()
+	closing := span.End.Offset - 1
+	if len(fn.body) == 0 && !f.commentsBefore(closing) {
+		return header + " {}"
+	}
+	body := &Formatter{source: f.source, comments: f.comments, indent: 1, lastLine: span.Start.Line, opened: true}
+	body.lines = []formattedLine{{text: header + " {"}}
+	body.statements(fn.body, closing)
+	f.comments = body.comments
+	return body.String() + "}"
+}
+
+func (f *Formatter) exprs(exprs []Expr) []string {
+	out := make([]string, len(exprs))
+	for idx, e := range exprs {
+		out[idx] = f.expr(e)
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package lox
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	source := `// Leading comment.
+var   a=1;var b = "x\ty";   // trailing
+
+
+fun add(x,y){return x+y;}
+class A < B { init(n) { this.n = -n; }
+
+  // Between methods.
+  get() { return this.n; } }
+if (a>0) print a; else if (a<0) { print -a; } else print "zero";
+for (var i = 0; i < 3; i = i + 1) { print i; }
+for (;;) {
+  // Only a comment.
+}
+while (!(a == b) and true) a = add(a, 1);
+{
+}
+// Done.`
+	want := `// Leading comment.
+var a = 1;
+var b = "x\ty";  // trailing
+
+fun add(x, y) {
+  return x + y;
+}
+class A < B {
+  init(n) {
+    this.n = -n;
+  }
+
+  // Between methods.
+  get() {
+    return this.n;
+  }
+}
+if (a > 0) print a;
+else if (a < 0) {
+  print -a;
+} else print "zero";
+for (var i = 0; i < 3; i = i + 1) {
+  print i;
+}
+for (;;) {
+  // Only a comment.
+}
+while (!(a == b) and true) a = add(a, 1);
+{}
+// Done.
+`
+	got, err := Format(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestFormat_FunctionExpressions(t *testing.T) {
+	source := `fun outer() {
+  var f = fun(a,b){ // Adds.
+    return a+b;
+  };
+  return xs.map(x=>x*2).filter( (n) => {
+
+    // Keep big ones.
+    return n > 2; });
+}
+var g = () => {};`
+	want := `fun outer() {
+  var f = fun (a, b) {  // Adds.
+    return a + b;
+  };
+  return xs.map(x => x * 2).filter((n) => {
+    // Keep big ones.
+    return n > 2;
+  });
+}
+var g = () => {};
+`
+	got, err := Format(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestFormat_CommentsInExpressions(t *testing.T) {
+	source := `fun f(a, b) {
+  return a + // Left.
+    b;
+}
+var total = sum(1, // One.
+  // Two.
+  2);
+print done( // Nothing yet.
+);`
+	want := `fun f(a, b) {
+  return a +  // Left.
+    b;
+}
+var total = sum(1,  // One.
+  // Two.
+  2);
+print done();
+// Nothing yet.
+`
+	got, err := Format(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+	again, err := Format(got)
+	if err != nil || again != got {
+		t.Fatalf("formatting is not idempotent:\n%s", again)
+	}
+}
+
+func TestFormat_TryStatements(t *testing.T) {
+	source := `try{ risky(); }catch(e){throw Error("failed: "+e);}
+finally { // Always.
+done(); }
+try {} // Nothing to do.
+finally {}`
+	want := `try {
+  risky();
+} catch (e) {
+  throw Error("failed: " + e);
+} finally {  // Always.
+  done();
+}
+try {}  // Nothing to do.
+finally {}
+`
+	got, err := Format(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestFormat_Errors(t *testing.T) {
+	source := "print (1;"
+	got, err := Format(source)
+	if err == nil || got != source {
+		t.Fatalf("expected the source back with an error, got %q, %v", got, err)
+	}
+}
+
+// TestFormat_Corpus checks that formatting every golden script only changes
+// layout, keeps every comment, and is idempotent.
+func TestFormat_Corpus(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.lox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		source, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := Format(string(source))
+		if err != nil {
+			// Scripts that test syntax errors cannot be formatted.
+			continue
+		}
+		again, err := Format(formatted)
+		if err != nil {
+			t.Fatalf("%s: formatted output does not parse: %v\n%s", path, err, formatted)
+		}
+		if again != formatted {
+			t.Errorf("%s: formatting is not idempotent\nfirst:\n%s\nsecond:\n%s", path, formatted, again)
+		}
+
+		before, after := NewScanner(), NewScanner()
+		before.Eval(string(source))
+		after.Eval(formatted)
+		if len(before.Tokens) != len(after.Tokens) || len(before.Comments) != len(after.Comments) {
+			t.Fatalf("%s: formatting changed the number of tokens or comments", path)
+		}
+		for idx := range before.Tokens {
+			if before.Tokens[idx].Lexeme != after.Tokens[idx].Lexeme {
+				t.Fatalf("%s: token %d changed from %q to %q", path,
+					idx, before.Tokens[idx].Lexeme, after.Tokens[idx].Lexeme)
+			}
+		}
+		for idx := range before.Comments {
+			if before.Comments[idx].Lexeme != after.Comments[idx].Lexeme {
+				t.Fatalf("%s: comment %d changed", path, idx)
+			}
+		}
+	}
+}
@@ -5,6 +5,13 @@ type LoxCallable interface {
 	Call(i *Interpreter, arguments ...interface{}) interface{}
 }
 
+// returnValue carries the value of a return statement up the Go stack to the
+// LoxFunction.Call executing it. The interpreter panics with it and Call
+// recovers it, so every enclosing block unwinds on the way out.
+type returnValue struct {
+	value interface{}
+}
+
 type LoxFunction struct {
 	isInitializer bool
 	declaration   *FunctionStmt
@@ -19,19 +26,42 @@ func (fn *LoxFunction) Arity() int {
 	return len(fn.declaration.params)
 }
 
-func (fn *LoxFunction) Call(i *Interpreter, arguments ...interface{}) interface{} {
+func (fn *LoxFunction) Call(i *Interpreter, arguments ...interface{}) (result interface{}) {
 	fnenv := NewLoxEnvironmentWithParent(fn.closure)
 	for idx, param := range fn.declaration.params {
 		fnenv.Define(param.Lexeme, arguments[idx])
 	}
-	i.executeBlock(fn.declaration.body, fnenv)
-	if fn.isInitializer {
-		result, _ := fn.closure.GetAt(0, "this")
-		return result
+
+	if n := len(i.frames); n >= i.maxCallDepth {
+		i.error(i.frames[n-1].call, "Stack overflow.")
 	}
+	i.frames = append(i.frames, traceFrame{function: fn})
+	defer func() {
+		if r := recover(); r != nil {
+			ret, ok := r.(*returnValue)
+			if !ok {
+				// The frame stays for the stack trace of the exception.
+				panic(r)
+			}
+			result = ret.value
+		}
+		i.frames = i.frames[:len(i.frames)-1]
+		// An initializer always hands back the instance, even on an early return.
+		if fn.isInitializer {
+			result = fn.closure.GetAt(0, 0)
+		}
+	}()
+	i.executeBlock(fn.declaration.body, fnenv)
 	return nil
 }
 
+func (fn *LoxFunction) String() string {
+	if fn.declaration.name.Lexeme == "" {
+		return "<fn>"
+	}
+	return "<fn " + fn.declaration.name.Lexeme + ">"
+}
+
 func (fn *LoxFunction) bind(i *LoxInstance) LoxCallable {
 	env := NewLoxEnvironmentWithParent(fn.closure)
 	env.Define("this", i)
@@ -0,0 +1,139 @@
+package lox
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Golden tests live in testdata/*.lox. Each script states what it must do
+// in comments, in the style of the Crafting Interpreters test suite:
+//
+//	print 1 + 2;  // expect: 3
+//	a();          // expect runtime error: Undefined variable 'a'.
+//	return 1;     // Error at 'return': Can't return from top-level code.
+//
+// Output is compared line by line. A runtime error must occur on the line
+// of its annotation. Scan, parse and resolve errors are written without the
+// "[line N]" prefix, which the harness derives from the annotation's line.
+var (
+	expectOutputPattern       = regexp.MustCompile(`// expect: ?(.*)`)
+	expectRuntimeErrorPattern = regexp.MustCompile(`// expect runtime error: (.+)`)
+	expectErrorPattern        = regexp.MustCompile(`// (Error.*)`)
+)
+
+type expectations struct {
+	output       []string
+	errors       []string
+	runtimeError string
+	runtimeLine  int
+}
+
+func parseExpectations(source string) expectations {
+	var expect expectations
+	for idx, line := range strings.Split(source, "\n") {
+		if m := expectRuntimeErrorPattern.FindStringSubmatch(line); m != nil {
+			expect.runtimeError = m[1]
+			expect.runtimeLine = idx + 1
+		} else if m := expectOutputPattern.FindStringSubmatch(line); m != nil {
+			expect.output = append(expect.output, m[1])
+		} else if m := expectErrorPattern.FindStringSubmatch(line); m != nil {
+			expect.errors = append(expect.errors, fmt.Sprintf("[line %d] %s", idx+1, m[1]))
+		}
+	}
+	return expect
+}
+
+func TestGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.lox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no golden scripts found in testdata")
+	}
+
+	for _, path := range paths {
+		source, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := parseExpectations(string(source))
+		for name, kind := range backends {
+			t.Run(name+"/"+filepath.Base(path), func(t *testing.T) {
+				runGolden(t, kind, path, string(source), expect)
+			})
+		}
+	}
+}
+
+// runGolden runs the script at path, so that the modules it imports are
+// found relative to it.
+func runGolden(t *testing.T, kind BackendKind, path string, source string, expect expectations) {
+	var errs []string
+	var runtimeErr *RuntimeError
+
+	var out bytes.Buffer
+	err := func() error {
+		lexer := NewScanner()
+		lexer.File = path
+		if err := lexer.Eval(source); err != nil {
+			return err
+		}
+		statements, err := NewParser(lexer.Tokens).Parse()
+		if err != nil {
+			return err
+		}
+		return NewBackendWithOutput(kind, &out, ioutil.Discard).Interpret(statements)
+	}()
+	output := out.String()
+
+	switch err := err.(type) {
+	case nil:
+	case ErrorList:
+		for _, e := range err {
+			errs = append(errs, e.Error())
+		}
+	case *RuntimeError:
+		runtimeErr = err
+	default:
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if strings.Join(errs, "\n") != strings.Join(expect.errors, "\n") {
+		t.Errorf("expected errors\n%s\ngot\n%s", strings.Join(expect.errors, "\n"), strings.Join(errs, "\n"))
+	}
+
+	if expect.runtimeError == "" && runtimeErr != nil {
+		t.Errorf("unexpected runtime error %q on line %d", runtimeErr.Message, runtimeErr.Line)
+	}
+	if expect.runtimeError != "" {
+		if runtimeErr == nil {
+			t.Errorf("expected runtime error %q on line %d", expect.runtimeError, expect.runtimeLine)
+		} else if runtimeErr.Message != expect.runtimeError || runtimeErr.Line != expect.runtimeLine {
+			t.Errorf("expected runtime error %q on line %d, got %q on line %d",
+				expect.runtimeError, expect.runtimeLine, runtimeErr.Message, runtimeErr.Line)
+		}
+	}
+
+	var lines []string
+	if output != "" {
+		lines = strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	}
+	for idx := 0; idx < len(lines) || idx < len(expect.output); idx++ {
+		var got, want string
+		if idx < len(lines) {
+			got = lines[idx]
+		}
+		if idx < len(expect.output) {
+			want = expect.output[idx]
+		}
+		if idx >= len(lines) || idx >= len(expect.output) || got != want {
+			t.Errorf("output line %d: expected %q, got %q", idx+1, want, got)
+		}
+	}
+}
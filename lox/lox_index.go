@@ -0,0 +1,268 @@
+package lox
+
+import (
+	"sort"
+	"strings"
+)
+
+// SymbolKind classifies a declaration recorded by Analyze.
+type SymbolKind int
+
+const (
+	VariableSymbol SymbolKind = iota
+	ParameterSymbol
+	FunctionSymbol
+	ClassSymbol
+	MethodSymbol
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case ParameterSymbol:
+		return "parameter"
+	case FunctionSymbol:
+		return "function"
+	case ClassSymbol:
+		return "class"
+	case MethodSymbol:
+		return "method"
+	}
+	return "variable"
+}
+
+// Symbol is a name declared in a Lox script.
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	// Global is set for declarations in top-level code.
+	Global bool
+	// NameSpan covers the declaring identifier, DeclSpan the whole
+	// declaration.
+	NameSpan Span
+	DeclSpan Span
+	// Detail is the declaration's signature, such as "fun fib(n)".
+	Detail string
+	// Parent is the class of a method; Children are the methods of a class.
+	Parent   *Symbol
+	Children []*Symbol
+}
+
+// Reference is a use of a declared name.
+type Reference struct {
+	Span   Span
+	Symbol *Symbol
+}
+
+// Diagnostic is an error or warning found by Analyze.
+type Diagnostic struct {
+	Span    Span
+	Warning bool
+	Message string
+	// Check names the lint check that produced a warning.
+	Check string
+}
+
+// Analysis holds what Analyze learned about one script: its diagnostics,
+// every declaration and every use the resolver could tie to one.
+type Analysis struct {
+	File        string
+	Statements  []Stmt
+	Diagnostics []Diagnostic
+	Symbols     []*Symbol
+	References  []*Reference
+
+	globals map[string]*Symbol
+	// pending holds uses of names not found in any local scope. They are
+	// tied to globals once the whole script is resolved, since a function
+	// may use a global declared after it.
+	pending []Token
+	scopes  []analyzedScope
+}
+
+// analyzedScope records a local scope with its declarations, for completion.
+type analyzedScope struct {
+	span    Span
+	symbols []*Symbol
+}
+
+// Analyze scans, parses and lints source without running it. Syntax errors
+// do not stop the analysis; whatever parsed is still indexed.
+func Analyze(file string, source string) *Analysis {
+	a := &Analysis{File: file, globals: make(map[string]*Symbol)}
+
+	scanner := NewScanner()
+	scanner.File = file
+	if err, ok := scanner.Eval(source).(ErrorList); ok {
+		for _, e := range err {
+			serr := e.(*ScanError)
+			start := Position{Line: serr.Line, Column: serr.Column}
+			a.Diagnostics = append(a.Diagnostics, Diagnostic{
+				Span: Span{File: file, Start: start, End: start}, Message: serr.Message,
+			})
+		}
+	}
+
+	statements, err := NewParser(scanner.Tokens).Parse()
+	a.Statements = statements
+	if errs, ok := err.(ErrorList); ok {
+		for _, e := range errs {
+			perr := e.(*ParseError)
+			a.Diagnostics = append(a.Diagnostics, Diagnostic{Span: perr.Token.Span(), Message: perr.Message})
+		}
+	}
+
+	linter := NewLinter()
+	linter.analysis = a
+	if errs, ok := linter.Resolve(statements).(ErrorList); ok {
+		for _, e := range errs {
+			rerr := e.(*ResolveError)
+			a.Diagnostics = append(a.Diagnostics, Diagnostic{Span: rerr.Token.Span(), Message: rerr.Message})
+		}
+	}
+	for _, w := range linter.Warnings() {
+		a.Diagnostics = append(a.Diagnostics, Diagnostic{
+			Span: w.Token.Span(), Warning: true, Message: w.Message, Check: w.Check,
+		})
+	}
+
+	for _, name := range a.pending {
+		if sym, ok := a.globals[name.Lexeme]; ok {
+			a.References = append(a.References, &Reference{Span: name.Span(), Symbol: sym})
+		}
+	}
+	a.pending = nil
+	sort.SliceStable(a.References, func(i, j int) bool {
+		return a.References[i].Span.Start.Offset < a.References[j].Span.Start.Offset
+	})
+	return a
+}
+
+// Lookup returns the symbol declared or used at the given line and column,
+// along with the span of the name found there.
+func (a *Analysis) Lookup(line int, column int) (*Symbol, Span, bool) {
+	for _, sym := range a.Symbols {
+		if sym.NameSpan.Contains(line, column) {
+			return sym, sym.NameSpan, true
+		}
+	}
+	for _, ref := range a.References {
+		if ref.Span.Contains(line, column) {
+			return ref.Symbol, ref.Span, true
+		}
+	}
+	return nil, Span{}, false
+}
+
+// ReferencesTo returns the spans of every use of sym, in source order.
+func (a *Analysis) ReferencesTo(sym *Symbol) []Span {
+	var spans []Span
+	for _, ref := range a.References {
+		if ref.Symbol == sym {
+			spans = append(spans, ref.Span)
+		}
+	}
+	return spans
+}
+
+// Visible returns the symbols that code at the given line and column can
+// refer to by name: locals declared before it in enclosing scopes, innermost
+// first, then every global. Methods are not included.
+func (a *Analysis) Visible(line int, column int) []*Symbol {
+	var visible []*Symbol
+	seen := make(map[string]bool)
+	add := func(sym *Symbol) {
+		if !seen[sym.Name] {
+			seen[sym.Name] = true
+			visible = append(visible, sym)
+		}
+	}
+
+	// Scopes are recorded as they close, so inner scopes come first.
+	for _, scope := range a.scopes {
+		if !scope.span.Contains(line, column) {
+			continue
+		}
+		for idx := len(scope.symbols) - 1; idx >= 0; idx-- {
+			sym := scope.symbols[idx]
+			if before(sym.NameSpan.Start, line, column) {
+				add(sym)
+			}
+		}
+	}
+	for _, sym := range a.Symbols {
+		if sym.Global {
+			add(sym)
+		}
+	}
+	return visible
+}
+
+// Contains reports whether the 1-based line and column fall within s. The
+// end of the span counts as inside, so a cursor just after a name finds it.
+func (s Span) Contains(line int, column int) bool {
+	if line < s.Start.Line || line > s.End.Line {
+		return false
+	}
+	if line == s.Start.Line && column < s.Start.Column {
+		return false
+	}
+	if line == s.End.Line && column > s.End.Column {
+		return false
+	}
+	return true
+}
+
+func before(p Position, line int, column int) bool {
+	return p.Line < line || (p.Line == line && p.Column < column)
+}
+
+// declare records a declaration made while resolving. Names declared in a
+// local scope are also remembered there so uses can find them.
+func (a *Analysis) declare(name Token, kind SymbolKind, decl Stmt, detail string, scope *Scope) *Symbol {
+	sym := &Symbol{
+		Name:     name.Lexeme,
+		Kind:     kind,
+		Global:   scope == nil && kind != MethodSymbol,
+		NameSpan: name.Span(),
+		DeclSpan: decl.Span(),
+		Detail:   detail,
+	}
+	a.Symbols = append(a.Symbols, sym)
+	if scope != nil {
+		scope.symbols[name.Lexeme] = sym
+	} else if kind != MethodSymbol {
+		if _, ok := a.globals[name.Lexeme]; !ok {
+			a.globals[name.Lexeme] = sym
+		}
+	}
+	return sym
+}
+
+func (a *Analysis) use(name Token, scope *Scope) {
+	if scope == nil {
+		a.pending = append(a.pending, name)
+		return
+	}
+	if sym, ok := scope.symbols[name.Lexeme]; ok {
+		a.References = append(a.References, &Reference{Span: name.Span(), Symbol: sym})
+	}
+}
+
+func (a *Analysis) closeScope(scope *Scope) {
+	var symbols []*Symbol
+	for _, sym := range scope.symbols {
+		symbols = append(symbols, sym)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].NameSpan.Start.Offset < symbols[j].NameSpan.Start.Offset
+	})
+	a.scopes = append(a.scopes, analyzedScope{span: scope.span, symbols: symbols})
+}
+
+func functionDetail(f *FunctionStmt) string {
+	params := make([]string, len(f.params))
+	for idx, param := range f.params {
+		params[idx] = param.Lexeme
+	}
+	return f.name.Lexeme + "(" + strings.Join(params, ", ") + ")"
+}
@@ -0,0 +1,102 @@
+package lox
+
+import (
+	"strings"
+	"testing"
+)
+
+const analyzed = `var total = 0;
+fun add(n) {
+  var next = total + n;
+  total = next;
+  return next;
+}
+class Counter < Base {
+  init(start) {
+    this.count = start;
+  }
+}
+add(1);
+print undefined;
+var = 2;`
+
+func TestAnalyze_Symbols(t *testing.T) {
+	a := Analyze("index.lox", analyzed)
+
+	var names []string
+	for _, sym := range a.Symbols {
+		names = append(names, sym.Kind.String()+" "+sym.Name)
+	}
+	want := []string{"variable total", "function add", "parameter n", "variable next",
+		"class Counter", "method init", "parameter start"}
+	if len(names) != len(want) {
+		t.Fatalf("expected symbols %v, got %v", want, names)
+	}
+	for idx := range want {
+		if names[idx] != want[idx] {
+			t.Errorf("expected %q, got %q", want[idx], names[idx])
+		}
+	}
+
+	class := a.Symbols[4]
+	if class.Detail != "class Counter < Base" || len(class.Children) != 1 || class.Children[0].Parent != class {
+		t.Errorf("unexpected class symbol %+v", class)
+	}
+	if method := a.Symbols[5]; method.Detail != "method Counter.init(start)" || method.Global {
+		t.Errorf("unexpected method symbol %+v", method)
+	}
+}
+
+func TestAnalyze_References(t *testing.T) {
+	a := Analyze("index.lox", analyzed)
+
+	// The use of total inside add resolves to the global.
+	sym, span, ok := a.Lookup(3, 15)
+	if !ok || sym.Name != "total" || !sym.Global || span.Start.Line != 3 {
+		t.Fatalf("expected the global total, got %+v", sym)
+	}
+	refs := a.ReferencesTo(sym)
+	if len(refs) != 2 || refs[0].Start.Line != 3 || refs[1].Start.Line != 4 {
+		t.Fatalf("expected total used on lines 3 and 4, got %v", refs)
+	}
+
+	next, _, ok := a.Lookup(5, 10)
+	if !ok || next.Kind != VariableSymbol || next.NameSpan.Start.Line != 3 {
+		t.Fatalf("expected return next to resolve to the local, got %+v", next)
+	}
+	if _, _, ok := a.Lookup(13, 8); ok {
+		t.Fatal("expected no symbol for an undefined name")
+	}
+}
+
+func TestAnalyze_Visible(t *testing.T) {
+	a := Analyze("index.lox", analyzed)
+
+	var names []string
+	for _, sym := range a.Visible(4, 3) {
+		names = append(names, sym.Name)
+	}
+	want := "next n total add Counter"
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("expected %q in scope, got %q", want, got)
+	}
+}
+
+func TestAnalyze_Diagnostics(t *testing.T) {
+	a := Analyze("index.lox", analyzed)
+
+	var errors, warnings int
+	for _, d := range a.Diagnostics {
+		if d.Warning {
+			warnings++
+		} else {
+			errors++
+			if d.Span.Start.Line != 14 || d.Message != "Expect variable name." {
+				t.Errorf("unexpected error %+v", d)
+			}
+		}
+	}
+	if errors != 1 || warnings != 0 {
+		t.Fatalf("expected one error and no warnings, got %+v", a.Diagnostics)
+	}
+}
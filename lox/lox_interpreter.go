@@ -1,31 +1,217 @@
 package lox
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"os"
 )
 
-type Interpreter struct {
-	env     *LoxEnvironment
-	globals *LoxEnvironment
-	locals  map[Expr]int
+// localRef locates a resolved local: the number of environments to walk up
+// and the slot within that environment.
+type localRef struct {
+	depth int
+	slot  int
 }
 
-func NewInterpreter() *Interpreter {
-	env := NewLoxEnvironment()
-	env.Define("clock", &ClockFunction{})
+// loopJump carries a break or continue statement up the Go stack to the
+// loop executing it, the way returnValue carries a return to its function.
+type loopJump struct {
+	isBreak bool
+}
+
+// traceFrame is a LoxFunction being called, or top-level code when function
+// is nil, with the call site of the last call it made.
+type traceFrame struct {
+	function *LoxFunction
+	call     Token
+}
+
+// DefaultMaxCallDepth is how many calls a backend lets be active at once,
+// counting the script itself, unless SetMaxCallDepth says otherwise. Deeper
+// recursion is a "Stack overflow." runtime error rather than a crash of the
+// Go runtime.
+const DefaultMaxCallDepth = 1000
+
+// maxInterpreterCallDepth caps the call depth of the tree-walker, whose Lox
+// calls recurse on the Go stack and take several kilobytes of it each; far
+// deeper recursion would exhaust the Go stack, which is fatal.
+const maxInterpreterCallDepth = 50000
 
-	i := &Interpreter{globals: NewLoxEnvironment()}
+type Interpreter struct {
+	env         *LoxEnvironment
+	globals     *LoxEnvironment
+	locals      map[Expr]localRef
+	out         io.Writer
+	diagnostics io.Writer
+	input       *lineInput
+	natives     []*NativeFunction
+	modules     *moduleLoader
+	// frames holds the code being run, innermost last, for stack traces.
+	// An exception leaves the frames it unwinds in place until it is
+	// caught, so its stack trace can still be taken.
+	frames       []traceFrame
+	maxCallDepth int
+	budget       budget
+	capabilities Capability
+}
+
+// NewInterpreter returns an interpreter that prints to os.Stdout and reports
+// errors to os.Stderr.
+func NewInterpreter() *Interpreter {
+	return NewInterpreterWithOutput(os.Stdout, os.Stderr)
+}
+
+// NewInterpreterWithOutput returns an interpreter whose print statements
+// write to out and which reports every error Interpret returns to
+// diagnostics. Interpreters sharing no writers can run concurrently.
+func NewInterpreterWithOutput(out io.Writer, diagnostics io.Writer) *Interpreter {
+	globals := NewLoxEnvironment()
+	i := &Interpreter{
+		env:          globals,
+		globals:      globals,
+		locals:       make(map[Expr]localRef),
+		out:          out,
+		diagnostics:  diagnostics,
+		input:        newLineInput(os.Stdin),
+		modules:      newModuleLoader(),
+		maxCallDepth: DefaultMaxCallDepth,
+		capabilities: AllCapabilities,
+	}
+	defineStdlib(i.DefineNative, i.input)
 	return i
 }
 
-func (i *Interpreter) Interpret(statements []Stmt) {
+// DefineNative exposes fn to scripts as a global function called name.
+// Scripts calling it with other than arity arguments get a runtime error, as
+// does a call for which fn returns an error.
+func (i *Interpreter) DefineNative(name string, arity int, fn NativeFunc) {
+	i.defineNative(NewNativeFunction(name, arity, fn))
+}
+
+// DefineNativeCallback is DefineNative for a native that calls Lox
+// functions, typically ones passed to it as arguments.
+func (i *Interpreter) DefineNativeCallback(name string, arity int, fn NativeCallbackFunc) {
+	i.defineNative(NewNativeCallbackFunction(name, arity, fn))
+}
+
+// defineNative defines native as a global of the program and of every
+// module it imports.
+func (i *Interpreter) defineNative(native *NativeFunction) {
+	i.globals.Define(native.name, native)
+	i.natives = append(i.natives, native)
+}
+
+// SetInput makes the input native read lines from in instead of os.Stdin.
+func (i *Interpreter) SetInput(in io.Reader) {
+	i.input.reader = bufio.NewReader(in)
+}
+
+// SetSearchPath sets the directories searched for a module that is not
+// found relative to the file importing it.
+func (i *Interpreter) SetSearchPath(dirs ...string) {
+	i.modules.searchPath = dirs
+}
+
+// SetMaxCallDepth limits how many calls may be active at once, counting
+// the script itself. A depth below one restores DefaultMaxCallDepth, and one
+// above maxInterpreterCallDepth is lowered to it.
+func (i *Interpreter) SetMaxCallDepth(depth int) {
+	if depth < 1 {
+		depth = DefaultMaxCallDepth
+	}
+	if depth > maxInterpreterCallDepth {
+		depth = maxInterpreterCallDepth
+	}
+	i.maxCallDepth = depth
+}
+
+// SetLimits bounds the work each program Interpret runs may do.
+func (i *Interpreter) SetLimits(limits Limits) {
+	i.budget.limits = limits
+	i.SetMaxCallDepth(limits.MaxCallDepth)
+}
+
+// SetCapabilities withholds the natives and statements of every capability
+// not in caps, and grants back those in it.
+func (i *Interpreter) SetCapabilities(caps Capability) {
+	i.capabilities = caps
+	for _, native := range capabilityNatives(i.input) {
+		i.withdrawNative(native.name)
+		if caps&native.capability != 0 {
+			i.DefineNative(native.name, native.arity, native.fn)
+		}
+	}
+}
+
+// withdrawNative undoes defineNative for the native called name.
+func (i *Interpreter) withdrawNative(name string) {
+	delete(i.globals.values, name)
+	natives := i.natives[:0]
+	for _, native := range i.natives {
+		if native.name != name {
+			natives = append(natives, native)
+		}
+	}
+	i.natives = natives
+}
+
+// Get returns the value of the global variable name and whether it is defined.
+func (i *Interpreter) Get(name string) (Value, bool) {
+	return i.globals.Get(name)
+}
+
+// Set defines the global variable name, replacing any previous value.
+// Go integers and float32 are stored as Lox numbers.
+func (i *Interpreter) Set(name string, value Value) {
+	i.globals.Define(name, toLoxValue(value))
+}
+
+// Interpret resolves and executes statements. A resolve error stops the
+// program before it runs; a *RuntimeError stops it where the fault occurred
+// or where a value was thrown that no try statement caught, and a
+// *LimitError where it exceeded its Limits. Each is also written to the
+// diagnostics writer.
+func (i *Interpreter) Interpret(statements []Stmt) error {
+	return i.InterpretContext(context.Background(), statements)
+}
+
+// InterpretContext is Interpret for a program that stops with a *LimitError
+// once ctx is done.
+func (i *Interpreter) InterpretContext(ctx context.Context, statements []Stmt) (err error) {
+	defer func() {
+		if err != nil {
+			fmt.Fprintln(i.diagnostics, err)
+		}
+	}()
 
 	resolver := NewResolver(i)
-	resolver.Resolve(statements)
+	if err := resolver.Resolve(statements); err != nil {
+		return err
+	}
+
+	i.frames = append(i.frames[:0], traceFrame{})
+	i.budget.start(ctx)
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case *RuntimeError:
+				err = e
+			case *LimitError:
+				err = e
+			case *thrownValue:
+				err = e.runtimeError()
+			default:
+				panic(r)
+			}
+		}
+	}()
 	for _, stmt := range statements {
 		i.execute(stmt)
 	}
+	return nil
 }
 
 func (i *Interpreter) VisitVariableStmt(s *VariableStmt) interface{} {
@@ -33,7 +219,7 @@ func (i *Interpreter) VisitVariableStmt(s *VariableStmt) interface{} {
 	if s.initializer != nil {
 		value = i.evaluate(s.initializer)
 	}
-	i.globals.Define(s.name.Lexeme, value)
+	i.env.Define(s.name.Lexeme, value)
 	return nil
 }
 
@@ -43,7 +229,7 @@ func (i *Interpreter) VisitExprStmt(e *ExprStmt) interface{} {
 }
 
 func (i *Interpreter) VisitPrintStmt(p *PrintStmt) interface{} {
-	fmt.Printf("%v", i.evaluate(p.expression))
+	fmt.Fprintln(i.out, stringify(i.evaluate(p.expression)))
 	return nil
 }
 
@@ -59,23 +245,20 @@ func (i *Interpreter) VisitBinaryExpr(e *BinaryExpr) interface{} {
 	left := i.evaluate(e.left)
 	right := i.evaluate(e.right)
 
-	var leftf float64
-	var rightf float64
-
-	switch right.(type) {
-	case float64:
-		rightf = right.(float64)
-	default:
-		return "**ERR**"
-	}
-
-	switch left.(type) {
-	case float64:
-		leftf = left.(float64)
-	default:
-		return "**ERR**"
+	switch e.operator.TokenType {
+	case BangEqual:
+		return !isEqual(left, right)
+	case EqualEqual:
+		return isEqual(left, right)
+	case PLUS:
+		sum, ok := add(left, right)
+		if !ok {
+			i.error(e.operator, "Operands must be two numbers or at least one string.")
+		}
+		return i.allocate(e.operator.Line, sum)
 	}
 
+	leftf, rightf := i.checkNumberOperands(e.operator, left, right)
 	switch e.operator.TokenType {
 	case MINUS:
 		return leftf - rightf
@@ -83,9 +266,6 @@ func (i *Interpreter) VisitBinaryExpr(e *BinaryExpr) interface{} {
 		return leftf / rightf
 	case STAR:
 		return leftf * rightf
-	case PLUS:
-		//TODO string concat
-		return leftf + rightf
 	case GREATER:
 		return leftf > rightf
 	case GreaterEqual:
@@ -94,12 +274,17 @@ func (i *Interpreter) VisitBinaryExpr(e *BinaryExpr) interface{} {
 		return leftf < rightf
 	case LessEqual:
 		return leftf <= rightf
-	case BangEqual:
-		return !i.isEqual(left, right)
-	case EqualEqual:
-		return i.isEqual(left, right)
 	}
-	return "**ERR**"
+	return nil
+}
+
+func (i *Interpreter) checkNumberOperands(operator Token, left interface{}, right interface{}) (float64, float64) {
+	leftf, lok := left.(float64)
+	rightf, rok := right.(float64)
+	if !lok || !rok {
+		i.error(operator, "Operands must be numbers.")
+	}
+	return leftf, rightf
 }
 
 func (i *Interpreter) VisitLiteralExpr(e *LiteralExpr) interface{} {
@@ -111,85 +296,58 @@ func (i *Interpreter) VisitUnaryExpr(e *UnaryExpr) interface{} {
 
 	switch e.operator.TokenType {
 	case BANG:
-		return !i.isTrue(right)
+		return !isTruthy(right)
 	case MINUS:
-		switch right.(type) {
-		case int:
-			return -1 * right.(int)
-		case float32:
-			return -1 * right.(float32)
-		case float64:
-			return -1 * right.(float64)
+		value, ok := right.(float64)
+		if !ok {
+			i.error(e.operator, "Operand must be a number.")
 		}
-		return "**ERR**"
+		return -value
 	}
-	return "*ERR*"
+	return nil
 }
 
 func (i *Interpreter) evaluate(expr Expr) interface{} {
 	return expr.Accept(i)
 }
 
-func (i *Interpreter) isTrue(right interface{}) bool {
-	if right == nil {
-		return false
-	}
-
-	switch right.(type) {
-	case bool:
-		return !right.(bool)
-	}
-	return true
-}
-
-func (i *Interpreter) isEqual(left interface{}, right interface{}) bool {
-	if left == nil && right == nil {
-		return true
-	}
-	if left == nil && right != nil {
-		return false
-	}
-	if left != nil && right == nil {
-		return false
-	}
-	//TODO implement!
-	return true
-}
-
 func (i *Interpreter) execute(stmt Stmt) {
 	stmt.Accept(i)
 }
 
-//TODO cannot assign to unknown variable!!
 func (i *Interpreter) VisitAssignExpr(e *AssignExpr) interface{} {
 
 	value := i.evaluate(e.value)
-	dist, ok := i.locals[e]
+	ref, ok := i.locals[e]
 	if ok {
-		i.env.AssignAt(dist, e.name, value)
-	} else {
-		i.globals.Assign(e.name.Lexeme, value)
+		i.env.AssignAt(ref.depth, ref.slot, value)
+	} else if !i.env.globals.Assign(e.name.Lexeme, value) {
+		i.error(e.name, "Undefined variable '"+e.name.Lexeme+"'.")
 	}
 
 	return value
 }
 
 func (i *Interpreter) VisitBlockStmt(b *BlockStmt) interface{} {
-	i.executeBlock(b.statements, NewLoxEnvironmentWithParent(i.globals))
+	i.executeBlock(b.statements, NewLoxEnvironmentWithParent(i.env))
 	return nil
 }
 
+// executeBlock runs statements in env and restores the enclosing environment
+// afterwards, including when a return or runtime error unwinds through it.
 func (i *Interpreter) executeBlock(statements []Stmt, env *LoxEnvironment) {
-	prev := i.globals
-	i.globals = env
+	prev := i.env
+	i.env = env
+	defer func() {
+		i.env = prev
+	}()
 	for _, stmt := range statements {
 		i.execute(stmt)
 	}
-	i.globals = prev
 }
 
 func (i *Interpreter) VisitIfStmt(ifstmt *IfStmt) interface{} {
-	if i.isTrue(i.evaluate(ifstmt.condition)) {
+	if isTruthy(i.evaluate(ifstmt.condition)) {
 		i.execute(ifstmt.thenBranch)
 	} else if ifstmt.elseBranch != nil {
 		i.execute(ifstmt.elseBranch)
@@ -202,11 +360,11 @@ func (i *Interpreter) VisitLogicalExpr(e *LogicalExpr) interface{} {
 
 	switch e.operator.TokenType {
 	case OR:
-		if i.isTrue(left) {
+		if isTruthy(left) {
 			return left
 		}
 	case AND:
-		if !i.isTrue(left) {
+		if !isTruthy(left) {
 			return left
 		}
 	}
@@ -215,12 +373,127 @@ func (i *Interpreter) VisitLogicalExpr(e *LogicalExpr) interface{} {
 }
 
 func (i *Interpreter) VisitWhileStmt(w *WhileStmt) interface{} {
-	for i.isTrue(i.evaluate(w.condition)) {
-		i.execute(w.body)
+	for isTruthy(i.evaluate(w.condition)) {
+		i.step(w.Span().Start.Line)
+		if i.executeLoopBody(w.body) {
+			break
+		}
 	}
 	return nil
 }
 
+func (i *Interpreter) VisitBreakStmt(b *BreakStmt) interface{} {
+	panic(&loopJump{isBreak: true})
+}
+
+func (i *Interpreter) VisitContinueStmt(c *ContinueStmt) interface{} {
+	panic(&loopJump{isBreak: false})
+}
+
+// executeLoopBody runs one iteration of a loop body and reports whether it
+// ended with break. A continue just ends the iteration early.
+func (i *Interpreter) executeLoopBody(body Stmt) (broke bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			jump, ok := r.(*loopJump)
+			if !ok {
+				panic(r)
+			}
+			broke = jump.isBreak
+		}
+	}()
+	i.execute(body)
+	return false
+}
+
+// VisitForStmt runs a for loop in its own environment, which holds the
+// variable the initializer declares.
+func (i *Interpreter) VisitForStmt(f *ForStmt) interface{} {
+	prev := i.env
+	i.env = NewLoxEnvironmentWithParent(i.env)
+	defer func() {
+		i.env = prev
+	}()
+	if f.initializer != nil {
+		i.execute(f.initializer)
+	}
+	for f.condition == nil || isTruthy(i.evaluate(f.condition)) {
+		i.step(f.Span().Start.Line)
+		if i.executeLoopBody(f.body) {
+			break
+		}
+		if f.increment != nil {
+			i.evaluate(f.increment)
+		}
+	}
+	return nil
+}
+
+// VisitForInStmt runs the body in a fresh environment for each element, so
+// closures created in the body capture that iteration's element.
+func (i *Interpreter) VisitForInStmt(f *ForInStmt) interface{} {
+	iterable := i.evaluate(f.iterable)
+	elements, err := iterate(iterable)
+	if err != nil {
+		i.error(f.name, err.Error())
+	}
+	prev := i.env
+	defer func() {
+		i.env = prev
+	}()
+	for idx := 0; idx < len(elements); idx++ {
+		i.env = NewLoxEnvironmentWithParent(prev)
+		i.env.Define(f.name.Lexeme, elements[idx])
+		i.step(f.Span().Start.Line)
+		if i.executeLoopBody(f.body) {
+			break
+		}
+		// A list is read live, so elements pushed by the body are visited.
+		if list, ok := iterable.(*LoxList); ok {
+			elements = list.elements
+		}
+	}
+	return nil
+}
+
+func (i *Interpreter) VisitListExpr(l *ListExpr) interface{} {
+	elements := make([]Value, len(l.elements))
+	for idx, element := range l.elements {
+		elements[idx] = i.evaluate(element)
+	}
+	return i.allocate(l.bracket.Line, NewLoxList(elements))
+}
+
+func (i *Interpreter) VisitMapExpr(m *MapExpr) interface{} {
+	result := NewLoxMap()
+	for idx, key := range m.keys {
+		k := i.evaluate(key)
+		if err := result.Set(k, i.evaluate(m.values[idx])); err != nil {
+			i.error(m.brace, err.Error())
+		}
+	}
+	return i.allocate(m.brace.Line, result)
+}
+
+func (i *Interpreter) VisitIndexExpr(e *IndexExpr) interface{} {
+	object := i.evaluate(e.object)
+	value, err := getIndex(object, i.evaluate(e.index))
+	if err != nil {
+		i.error(e.bracket, err.Error())
+	}
+	return value
+}
+
+func (i *Interpreter) VisitSetIndexExpr(s *SetIndexExpr) interface{} {
+	object := i.evaluate(s.object)
+	index := i.evaluate(s.index)
+	value := i.evaluate(s.value)
+	if err := setIndex(object, index, value); err != nil {
+		i.error(s.bracket, err.Error())
+	}
+	return value
+}
+
 func (i *Interpreter) VisitCallExpr(c *CallExpr) interface{} {
 	callee := i.evaluate(c.callee)
 
@@ -229,111 +502,344 @@ func (i *Interpreter) VisitCallExpr(c *CallExpr) interface{} {
 		arguments = append(arguments, i.evaluate(arg))
 	}
 
-	//TODO call the function
 	fn, ok := callee.(LoxCallable)
 
 	if !ok {
-		i.error("Can only call functions and classes.")
+		i.error(c.paren, "Can only call functions and classes.")
 	}
 
 	if len(arguments) != fn.Arity() {
-		i.error(fmt.Sprintf("Expected %d but got %d arguments.", fn.Arity, len(arguments)))
+		i.error(c.paren, fmt.Sprintf("Expected %d arguments but got %d.", fn.Arity(), len(arguments)))
 	}
 
-	fn.Call(i, arguments)
-	return nil
+	i.step(c.paren.Line)
+	if n := len(i.frames); n > 0 {
+		i.frames[n-1].call = c.paren
+	}
+	if native, ok := fn.(*NativeFunction); ok {
+		result, err := native.invoke(i.callback, arguments)
+		if err != nil {
+			i.error(c.paren, err.Error())
+		}
+		return i.allocate(c.paren.Line, result)
+	}
+
+	result := fn.Call(i, arguments...)
+	if _, ok := fn.(*LoxClass); ok {
+		i.allocate(c.paren.Line, result)
+	}
+	return result
 }
 
 func (i *Interpreter) VisitFunctionStmt(f *FunctionStmt) interface{} {
-	fn := NewLoxFunction(f, i.globals, false)
-	i.globals.Define(f.name.Lexeme, fn)
+	fn := i.allocate(f.name.Line, NewLoxFunction(f, i.env, false))
+	i.env.Define(f.name.Lexeme, fn)
 	return nil
 }
 
-func (i *Interpreter) error(msg string) {
-	log.Fatal(msg)
+// VisitImportStmt binds the exports of a module as globals of the program,
+// or of the module importing it. Imports only appear at the top level, so
+// the current environment is the global one.
+func (i *Interpreter) VisitImportStmt(s *ImportStmt) interface{} {
+	if i.capabilities&ImportCapability == 0 {
+		i.error(s.keyword, "Imports are not allowed.")
+	}
+	i.frames[len(i.frames)-1].call = s.keyword
+	module, err := i.modules.load(s.keyword.File, s.path.Literal.(string), i.runModule)
+	if err != nil {
+		i.error(s.keyword, err.Error())
+	}
+	if s.alias != nil {
+		i.env.Define(s.alias.Lexeme, module)
+		return nil
+	}
+	for _, name := range module.Names() {
+		i.env.Define(name, module.exports[name])
+	}
+	return nil
 }
 
-func (i *Interpreter) VisitReturnStmt(r *ReturnStmt) interface{} {
-	//var value interface{}
-	//if r.value != nil {
-	//	value = i.evaluate(r.value)
-	//}
-	//TODO we need to escape to call fn somehow
-	//in java its done with throw, dont know how to implement it
-	//cleanly in golang :(
+// runModule executes the statements of a module in globals of its own,
+// which start out holding only the natives. A runtime error unwinds through
+// it as usual.
+func (i *Interpreter) runModule(statements []Stmt) (func(name string) Value, error) {
+	if err := NewResolver(i).Resolve(statements); err != nil {
+		return nil, err
+	}
+	globals := NewLoxEnvironment()
+	for _, native := range i.natives {
+		globals.Define(native.name, native)
+	}
+	env := i.env
+	i.env = globals
+	defer func() {
+		i.env = env
+	}()
+	i.frames = append(i.frames, traceFrame{})
+	for _, stmt := range statements {
+		i.execute(stmt)
+	}
+	i.frames = i.frames[:len(i.frames)-1]
+	return func(name string) Value {
+		value, _ := globals.Get(name)
+		return value
+	}, nil
+}
+
+// callback is the Caller natives get. A runtime error in the function it
+// calls unwinds through the native like any other.
+func (i *Interpreter) callback(callee Value, args ...Value) (Value, error) {
+	fn, ok := callee.(LoxCallable)
+	if !ok {
+		return nil, errors.New("Can only call functions and classes.")
+	}
+	if len(args) != fn.Arity() {
+		return nil, fmt.Errorf("Expected %d arguments but got %d.", fn.Arity(), len(args))
+	}
+	if n := len(i.frames); n > 0 {
+		i.step(i.frames[n-1].call.Line)
+	}
+	if native, ok := fn.(*NativeFunction); ok {
+		return native.invoke(i.callback, args)
+	}
+	return fn.Call(i, args...), nil
+}
+
+// VisitFunctionExpr creates a closure over the current environment, the way
+// a function declaration does, without binding it to a name.
+func (i *Interpreter) VisitFunctionExpr(f *FunctionExpr) interface{} {
+	return i.allocate(f.keyword.Line, NewLoxFunction(f.declaration, i.env, false))
+}
+
+// VisitThrowStmt unwinds to the innermost try statement that catches.
+func (i *Interpreter) VisitThrowStmt(s *ThrowStmt) interface{} {
+	panic(throw(i.evaluate(s.value), s.keyword.Line, i.stackTrace))
+}
+
+// VisitTryStmt runs the body, and the catch clause if the body raised an
+// exception. The finally clause is deferred, so it also runs when a return,
+// break or exception leaves the statement.
+func (i *Interpreter) VisitTryStmt(s *TryStmt) interface{} {
+	if s.finallyBody != nil {
+		defer i.executeFinally(s.finallyBody, len(i.frames))
+	}
+	if s.catchBody == nil {
+		i.execute(s.body)
+		return nil
+	}
+	if thrown := i.executeTry(s.body); thrown != nil {
+		env := NewLoxEnvironmentWithParent(i.env)
+		env.Define(s.name.Lexeme, thrown.value)
+		i.executeBlock(s.catchBody.statements, env)
+	}
+	return nil
+}
+
+// executeTry runs the body of a try statement and returns the exception it
+// raised, if any, with the frames it unwound dropped.
+func (i *Interpreter) executeTry(body *BlockStmt) (thrown *thrownValue) {
+	depth := len(i.frames)
+	defer func() {
+		if r := recover(); r != nil {
+			if thrown = i.exception(r); thrown == nil {
+				panic(r)
+			}
+			i.frames = i.frames[:depth]
+		}
+	}()
+	i.execute(body)
+	return nil
+}
+
+// executeFinally runs a finally clause as a try statement is left, then
+// carries on with whatever was leaving it: a return, break, continue or
+// exception, unless the clause itself leaves some other way. A program
+// stopped by its limits skips the clause, as the VM does.
+func (i *Interpreter) executeFinally(block *BlockStmt, depth int) {
+	r := recover()
+	if _, ok := r.(*LimitError); ok {
+		panic(r)
+	}
+	if thrown := i.exception(r); thrown != nil {
+		r = thrown
+		i.frames = i.frames[:depth]
+	}
+	i.execute(block)
+	if r != nil {
+		panic(r)
+	}
+}
+
+// exception returns the exception a recovered panic value raises, taking
+// the stack trace of a runtime error, or nil when it is no exception.
+func (i *Interpreter) exception(r interface{}) *thrownValue {
+	switch e := r.(type) {
+	case *thrownValue:
+		return e
+	case *RuntimeError:
+		return raised(e)
+	}
 	return nil
 }
 
-func (i *Interpreter) resolve(e Expr, depth int) {
-	i.locals[e] = depth
+// stackTrace lists the frames being run, innermost first, with the
+// innermost one at line.
+func (i *Interpreter) stackTrace(line int) []string {
+	trace := make([]string, 0, len(i.frames))
+	for idx := len(i.frames) - 1; idx >= 0; idx-- {
+		frame := i.frames[idx]
+		if frame.function == nil {
+			trace = append(trace, traceEntry("", false, line))
+		} else {
+			name := frame.function.declaration.name.Lexeme
+			trace = append(trace, traceEntry(name, name == "", line))
+		}
+		if idx > 0 {
+			line = i.frames[idx-1].call.Line
+		}
+	}
+	return trace
+}
+
+// step counts a loop iteration or call at line against the limits of the
+// program, and stops the program if that exceeds them.
+func (i *Interpreter) step(line int) {
+	if err := i.budget.step(); err != nil {
+		i.stop(line, err)
+	}
+}
+
+// allocate counts value, created at line, against the limits of the
+// program and returns it.
+func (i *Interpreter) allocate(line int, value Value) Value {
+	if err := i.budget.allocate(value); err != nil {
+		i.stop(line, err)
+	}
+	return value
+}
+
+// stop aborts the running program with err, raised at line. Try statements
+// let it pass.
+func (i *Interpreter) stop(line int, err *LimitError) {
+	err.Line = line
+	err.Stack = i.stackTrace(line)
+	panic(err)
+}
+
+// error aborts the running program with a *RuntimeError attributed to token,
+// carrying the stack trace at token. Interpret recovers it and hands it back
+// to the caller.
+func (i *Interpreter) error(token Token, msg string) {
+	err := NewRuntimeError(token, msg)
+	err.Stack = i.stackTrace(token.Line)
+	panic(err)
+}
+
+func (i *Interpreter) VisitReturnStmt(r *ReturnStmt) interface{} {
+	var value interface{}
+	if r.value != nil {
+		value = i.evaluate(r.value)
+	}
+	panic(&returnValue{value: value})
+}
+
+func (i *Interpreter) resolve(e Expr, depth int, slot int) {
+	i.locals[e] = localRef{depth: depth, slot: slot}
 }
 
 func (i *Interpreter) lookupVariable(name Token, e Expr) interface{} {
-	dist, ok := i.locals[e]
+	ref, ok := i.locals[e]
 	if ok {
-		varr, _ := i.env.GetAt(dist, name.Lexeme)
-		return varr
+		return i.env.GetAt(ref.depth, ref.slot)
 	} else {
-		varr, _ := i.globals.Get(name.Lexeme)
+		varr, ok := i.env.globals.Get(name.Lexeme)
+		if !ok {
+			i.error(name, "Undefined variable '"+name.Lexeme+"'.")
+		}
 		return varr
 	}
 }
 
 func (i *Interpreter) VisitClassStmt(c *ClassStmt) interface{} {
 
+	var superclass *LoxClass
 	if c.superclass != nil {
-		super := i.evaluate(c.superclass)
-		_, ok := super.(*LoxClass)
+		super, ok := i.evaluate(c.superclass).(*LoxClass)
 		if !ok {
-			i.error(c.superclass.name.Lexeme + "<Superclass must be a class.")
+			i.error(c.superclass.name, "Superclass must be a class.")
 		}
+		superclass = super
 	}
-	i.env.Define(c.name.Lexeme, nil)
 
-	if c.superclass != nil {
+	if superclass != nil {
 		i.env = NewLoxEnvironmentWithParent(i.env)
-		i.env.Define("super", c.superclass)
+		i.env.Define("super", superclass)
 	}
 
-	var methods map[string]LoxCallable
+	methods := make(map[string]*LoxFunction)
 	for _, method := range c.methods {
-		function := NewLoxFunction(method.(*FunctionStmt), i.env, method.(*FunctionStmt).name.Lexeme == "init")
-		methods[method.(*FunctionStmt).name.Lexeme] = function
+		declaration := method.(*FunctionStmt)
+		function := NewLoxFunction(declaration, i.env, declaration.name.Lexeme == "init")
+		i.allocate(declaration.name.Line, function)
+		methods[declaration.name.Lexeme] = function.(*LoxFunction)
 	}
-	class := NewLoxClass(c.name.Lexeme, methods)
+	class := NewLoxClass(c.name.Lexeme, superclass, methods)
+	i.allocate(c.name.Line, class)
 
-	if c.superclass != nil {
+	if superclass != nil {
 		i.env = i.env.parent
 	}
 
-	i.env.Assign(c.name.Lexeme, class)
+	// Methods only see the class through their closure once it runs, so the
+	// name can be defined last, in the slot the resolver gave it.
+	i.env.Define(c.name.Lexeme, class)
 	return nil
 }
 
 func (i *Interpreter) VisitGetExpr(g *GetExpr) interface{} {
 	object := i.evaluate(g.object)
-	instance, ok := object.(LoxInstance)
+	switch o := object.(type) {
+	case *LoxModule:
+		value, ok := o.Get(g.name.Lexeme)
+		if !ok {
+			i.error(g.name, "Undefined property '"+g.name.Lexeme+"'.")
+		}
+		return value
+	case *LoxError:
+		value, ok := o.Get(g.name.Lexeme)
+		if !ok {
+			i.error(g.name, "Undefined property '"+g.name.Lexeme+"'.")
+		}
+		return value
+	case *LoxList, *LoxMap:
+		method, ok := collectionMethod(object, g.name.Lexeme)
+		if !ok {
+			i.error(g.name, "Undefined property '"+g.name.Lexeme+"'.")
+		}
+		return method
+	}
+	instance, ok := object.(*LoxInstance)
+	if !ok {
+		i.error(g.name, "Only instances have properties.")
+	}
 
-	if ok {
-		instance.Get(g.name.Lexeme)
-	} else {
-		i.error(g.name.Lexeme + ":Only instances have properties.")
+	value, ok := instance.Get(g.name.Lexeme)
+	if !ok {
+		i.error(g.name, "Undefined property '"+g.name.Lexeme+"'.")
 	}
-	return nil
+	return value
 }
 
 func (i *Interpreter) VisitSetExpr(s *SetExpr) interface{} {
 	object := i.evaluate(s.object)
-	instance, ok := object.(LoxInstance)
-
-	if ok {
-		value := i.evaluate(s.value)
-		instance.Set(s.name.Lexeme, value)
-	} else {
-		i.error(s.name.Lexeme + ":Only instances have properties.")
+	instance, ok := object.(*LoxInstance)
+	if !ok {
+		i.error(s.name, "Only instances have fields.")
 	}
-	return nil
+
+	value := i.evaluate(s.value)
+	instance.Set(s.name.Lexeme, value)
+	return value
 }
 
 func (i *Interpreter) VisitThisExpr(t *ThisExpr) interface{} {
@@ -341,14 +847,16 @@ func (i *Interpreter) VisitThisExpr(t *ThisExpr) interface{} {
 }
 
 func (i *Interpreter) VisitSuperExpr(s *SuperExpr) interface{} {
-	distance := i.locals[s]
-	superclass, _ := i.env.GetAt(distance, "super")
-	instance, _ := i.env.GetAt(distance-1, "this")
+	// "super" and "this" are the only names in their scopes, so both sit in
+	// slot 0.
+	distance := i.locals[s].depth
+	superclass := i.env.GetAt(distance, 0)
+	instance := i.env.GetAt(distance-1, 0)
 	method := superclass.(*LoxClass).findMethod(s.method.Lexeme)
 
 	if method == nil {
-		i.error("Undefined property '" + s.method.Lexeme + "'.")
+		i.error(s.method, "Undefined property '"+s.method.Lexeme+"'.")
 	}
 
-	return method.(*LoxFunction).bind(instance.(*LoxInstance))
+	return method.bind(instance.(*LoxInstance))
 }
@@ -1,7 +1,11 @@
 package lox
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -10,12 +14,20 @@ func TestInterpreter_Print(t *testing.T) {
 	lexer := NewScanner()
 	lexer.Eval(expr)
 	parser := NewParser(lexer.Tokens)
-	ast := parser.Parse()
+	ast, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
 	//printer := NewAstPrinter()
 	//printer.Print(ast)
-	interpreter := NewInterpreter()
-	interpreter.Interpret(ast)
-	fmt.Println()
+	var out bytes.Buffer
+	interpreter := NewInterpreterWithOutput(&out, ioutil.Discard)
+	if err := interpreter.Interpret(ast); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\n" {
+		t.Fatalf("expected hello, got %q", out.String())
+	}
 }
 
 func TestInterpreter_Multiple(t *testing.T) {
@@ -25,8 +37,331 @@ func TestInterpreter_Multiple(t *testing.T) {
 	lexer := NewScanner()
 	lexer.Eval(prog)
 	parser := NewParser(lexer.Tokens)
-	ast := parser.Parse()
+	ast, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	interpreter := NewInterpreterWithOutput(&out, ioutil.Discard)
+	if err := interpreter.Interpret(ast); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "3\n" {
+		t.Fatalf("expected 3, got %q", out.String())
+	}
+}
+
+func TestInterpreter_RuntimeError(t *testing.T) {
+	prog := `var a = "not a function";
+	a();`
+	lexer := NewScanner()
+	lexer.Eval(prog)
+	parser := NewParser(lexer.Tokens)
+	ast, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diagnostics bytes.Buffer
+	interpreter := NewInterpreterWithOutput(ioutil.Discard, &diagnostics)
+	err = interpreter.Interpret(ast)
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got %v", err)
+	}
+	if rerr.Line != 2 || rerr.Message != "Can only call functions and classes." {
+		t.Fatalf("unexpected runtime error: %+v", rerr)
+	}
+	if diagnostics.String() != rerr.Error()+"\n" {
+		t.Fatalf("expected the error in diagnostics, got %q", diagnostics.String())
+	}
+}
+
+func TestInterpreter_StaticErrors(t *testing.T) {
+	lexer := NewScanner()
+	if _, ok := lexer.Eval("print \"open;").(ErrorList)[0].(*ScanError); !ok {
+		t.Fatal("expected a *ScanError for an unterminated string")
+	}
+
+	lexer.Eval("print (1;")
+	_, err := NewParser(lexer.Tokens).Parse()
+	if _, ok := err.(ErrorList)[0].(*ParseError); !ok {
+		t.Fatalf("expected a *ParseError, got %v", err)
+	}
+
+	lexer.Eval("return 1;")
+	ast, err := NewParser(lexer.Tokens).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewInterpreterWithOutput(ioutil.Discard, ioutil.Discard).Interpret(ast)
+	if _, ok := err.(ErrorList)[0].(*ResolveError); !ok {
+		t.Fatalf("expected a *ResolveError, got %v", err)
+	}
+}
+
+func TestInterpreter_Return(t *testing.T) {
+	prog := `fun add(a, b) {
+		{
+			var sum = a + b;
+			return sum;
+		}
+		print "unreachable";
+	}
+	fun makeAdder(n) {
+		fun adder(x) {
+			return add(x, n);
+		}
+		return adder;
+	}
+	var addTwo = makeAdder(2);
+	var five = addTwo(3);`
+	lexer := NewScanner()
+	lexer.Eval(prog)
+	parser := NewParser(lexer.Tokens)
+	ast, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
 	interpreter := NewInterpreter()
-	interpreter.Interpret(ast)
-	fmt.Println()
+	if err := interpreter.Interpret(ast); err != nil {
+		t.Fatal(err)
+	}
+	if interpreter.env != interpreter.globals {
+		t.Fatal("return did not restore the global environment")
+	}
+	five, _ := interpreter.globals.Get("five")
+	if five != 5.0 {
+		t.Fatalf("expected 5, got %v", five)
+	}
+}
+
+func TestInterpreter_DeepRecursion(t *testing.T) {
+	// Each call goes through nested statements, so it takes more of the Go
+	// stack than a bare recursive call would.
+	prog := `class A {
+		m(n) {
+			if (true) { while (true) { try { return [1, {1: 2}, -(1 + this.m(n + 1))]; } finally {} } }
+		}
+	}
+	A().m(0);`
+	interpreter := NewInterpreterWithOutput(ioutil.Discard, ioutil.Discard)
+	interpreter.SetMaxCallDepth(500000)
+	err := interpreter.Interpret(parse(t, prog))
+	rerr, ok := err.(*RuntimeError)
+	if !ok || rerr.Message != "Stack overflow." || len(rerr.Stack) != maxInterpreterCallDepth {
+		t.Fatalf("expected a stack overflow %d frames deep, got %v", maxInterpreterCallDepth, err)
+	}
+}
+
+func TestInterpreter_DefineNative(t *testing.T) {
+	prog := `var total = add(limit, 2);
+	fail();`
+	lexer := NewScanner()
+	lexer.Eval(prog)
+	parser := NewParser(lexer.Tokens)
+	ast, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	interpreter := NewInterpreterWithOutput(ioutil.Discard, ioutil.Discard)
+	interpreter.Set("limit", 40)
+	interpreter.DefineNative("add", 2, func(args []Value) (Value, error) {
+		return args[0].(float64) + args[1].(float64), nil
+	})
+	interpreter.DefineNative("fail", 0, func(args []Value) (Value, error) {
+		return nil, fmt.Errorf("host refused")
+	})
+
+	err = interpreter.Interpret(ast)
+	rerr, ok := err.(*RuntimeError)
+	if !ok || rerr.Message != "host refused" || rerr.Line != 2 {
+		t.Fatalf("expected the native's error on line 2, got %v", err)
+	}
+	total, ok := interpreter.Get("total")
+	if !ok || total != 42.0 {
+		t.Fatalf("expected total to be 42, got %v", total)
+	}
+}
+
+// conformance lists programs with the output they must print, one value per
+// line, on every backend.
+var conformance = []struct {
+	name string
+	prog string
+	want string
+}{
+	{"falsey values", `
+		if (false) print "false is truthy"; else print "false is falsey";
+		if (nil) print "nil is truthy"; else print "nil is falsey";
+		print !nil;
+		print !false;`,
+		"false is falsey\nnil is falsey\ntrue\ntrue"},
+	{"truthy values", `
+		if (true) print "true";
+		if (0) print "zero";
+		if ("") print "empty string";
+		if (clock) print "function";
+		print !0;
+		print !"";`,
+		"true\nzero\nempty string\nfunction\nfalse\nfalse"},
+	{"number equality", `
+		print 1 == 1;
+		print 1 == 2;
+		print 1 != 2;
+		print 0.1 + 0.2 == 0.3;
+		print 0 / 0 == 0 / 0;`,
+		"true\nfalse\ntrue\nfalse\nfalse"},
+	{"NaN is unordered", `
+		var nan = 0 / 0;
+		print nan < 1;
+		print nan <= 1;
+		print nan > 1;
+		print nan >= 1;
+		print nan <= nan;
+		print nan >= nan;
+		print nan != nan;`,
+		"false\nfalse\nfalse\nfalse\nfalse\nfalse\ntrue"},
+	{"string equality", `
+		print "a" == "a";
+		print "a" == "b";
+		print "a" + "b" == "ab";`,
+		"true\nfalse\ntrue"},
+	{"boolean and nil equality", `
+		print true == true;
+		print true == false;
+		print nil == nil;
+		print nil == false;
+		print false != nil;`,
+		"true\nfalse\ntrue\nfalse\ntrue"},
+	{"mixed types are unequal", `
+		print 1 == "1";
+		print 0 == false;
+		print "" == nil;
+		print "true" == true;`,
+		"false\nfalse\nfalse\nfalse"},
+	{"function identity", `
+		fun f() {}
+		fun g() {}
+		var h = f;
+		print f == f;
+		print f == h;
+		print f == g;
+		print clock == clock;`,
+		"true\ntrue\nfalse\ntrue"},
+	{"logical operators", `
+		print nil or "default";
+		print "set" or "default";
+		print 1 and 2;
+		print false and 2;
+		print nil and undefined;`,
+		"default\nset\n2\nfalse\nnil"},
+	{"loops", `
+		var i = 0;
+		while (i < 3) {
+			print i;
+			i = i + 1;
+		}
+		for (var j = 3; j > 0; j = j - 1) print j;`,
+		"0\n1\n2\n3\n2\n1"},
+	{"fib", `
+		fun fib(n) {
+			if (n < 2) return n;
+			return fib(n - 1) + fib(n - 2);
+		}
+		print fib(10);`,
+		"55"},
+	{"deep recursion", `
+		fun sum(n) {
+			if (n == 0) return 0;
+			return n + sum(n - 1);
+		}
+		print sum(500);`,
+		"125250"},
+	{"closures keep their binding", `
+		{
+			var a = "outer";
+			{
+				fun showA() {
+					print a;
+				}
+				showA();
+				var a = "inner";
+				showA();
+				print a;
+			}
+		}`,
+		"outer\nouter\ninner"},
+}
+
+func TestConformance(t *testing.T) {
+	for name, kind := range backends {
+		for _, tc := range conformance {
+			var out bytes.Buffer
+			err := NewBackendWithOutput(kind, &out, ioutil.Discard).Interpret(parse(t, tc.prog))
+			if err != nil {
+				t.Fatalf("%s/%s: %v", name, tc.name, err)
+			}
+			if got := out.String(); got != tc.want+"\n" {
+				t.Errorf("%s/%s: expected output\n%s\ngot\n%s", name, tc.name, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestInterpreter_ConcurrentOutput(t *testing.T) {
+	ast := parse(t, `for (var i = 0; i < 100; i = i + 1) print id;`)
+
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, 8)
+	for n := range outputs {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			interpreter := NewInterpreterWithOutput(&outputs[n], ioutil.Discard)
+			interpreter.Set("id", n)
+			if err := interpreter.Interpret(ast); err != nil {
+				t.Error(err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	for n := range outputs {
+		want := strings.Repeat(fmt.Sprintf("%d\n", n), 100)
+		if outputs[n].String() != want {
+			t.Fatalf("interpreter %d: output interleaved with another interpreter", n)
+		}
+	}
+}
+
+func benchmarkInterpreter(b *testing.B, prog string) {
+	ast := parse(b, prog)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := NewInterpreterWithOutput(ioutil.Discard, ioutil.Discard).Interpret(ast); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInterpreter_Fib(b *testing.B) {
+	benchmarkInterpreter(b, `fun fib(n) {
+		if (n < 2) return n;
+		return fib(n - 1) + fib(n - 2);
+	}
+	fib(20);`)
+}
+
+func BenchmarkInterpreter_Loop(b *testing.B) {
+	benchmarkInterpreter(b, `fun loop() {
+		var sum = 0;
+		for (var i = 0; i < 10000; i = i + 1) {
+			var half = i / 2;
+			{
+				sum = sum + half;
+			}
+		}
+		return sum;
+	}
+	loop();`)
 }
@@ -1,8 +1,9 @@
 package lox
 
 import (
-	"log"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -12,6 +13,9 @@ const (
 	RightParen
 	LeftBrace
 	RightBrace
+	LeftBracket
+	RightBracket
+	COLON
 	COMMA
 	DOT
 	MINUS
@@ -29,6 +33,7 @@ const (
 	GreaterEqual
 	LESS
 	LessEqual
+	ARROW
 
 	// Literals.
 	IDENTIFIER
@@ -37,24 +42,34 @@ const (
 
 	// Keywords.
 	AND
+	BREAK
+	CATCH
 	CLASS
+	CONTINUE
 	ELSE
 	FALSE
+	FINALLY
 	FUN
 	FOR
 	IF
+	IMPORT
 	NIL
 	OR
 	PRINT
 	RETURN
 	SUPER
 	THIS
+	THROW
 	TRUE
+	TRY
 	VAR
 	WHILE
 
 	EOF
 	ERROR
+	// COMMENT tokens are kept apart from the token stream, in
+	// Scanner.Comments.
+	COMMENT
 )
 
 var keywords map[string]TokenType
@@ -62,19 +77,26 @@ var keywords map[string]TokenType
 func init() {
 	keywords = make(map[string]TokenType)
 	keywords["and"] = AND
+	keywords["break"] = BREAK
+	keywords["catch"] = CATCH
 	keywords["class"] = CLASS
+	keywords["continue"] = CONTINUE
 	keywords["else"] = ELSE
 	keywords["false"] = FALSE
+	keywords["finally"] = FINALLY
 	keywords["for"] = FOR
 	keywords["fun"] = FUN
 	keywords["if"] = IF
+	keywords["import"] = IMPORT
 	keywords["nil"] = NIL
 	keywords["or"] = OR
 	keywords["print"] = PRINT
 	keywords["return"] = RETURN
 	keywords["super"] = SUPER
 	keywords["this"] = THIS
+	keywords["throw"] = THROW
 	keywords["true"] = TRUE
+	keywords["try"] = TRY
 	keywords["var"] = VAR
 	keywords["while"] = WHILE
 }
@@ -84,6 +106,12 @@ type Token struct {
 	Lexeme    string
 	Literal   interface{}
 	Line      int
+	// Column is the 1-based byte offset of the token within its line, or 0
+	// for tokens that were not scanned from source.
+	Column int
+	// Offset is the byte offset of the lexeme from the start of the source.
+	Offset int
+	File   string
 }
 
 func NewToken(tokenType TokenType, lexeme string, literal interface{}, line int) Token {
@@ -102,25 +130,34 @@ func NewErrorToken() Token {
 type Scanner struct {
 	Source string
 	Tokens []Token
-
-	start   int
-	current int
-	line    int
+	// File names the source in the tokens' spans. It is optional.
+	File string
+	// Comments holds the line comments of the source, which the parser
+	// never sees but a formatter must keep.
+	Comments []Token
+
+	start     int
+	current   int
+	line      int
+	lineStart int
+	column    int
+	errors    ErrorList
 }
 
 func NewScanner() Scanner {
 	return Scanner{current: 0, start: 0, line: 1}
 }
 
-func (s *Scanner) ScanTokens() {
-	for {
+func (s *Scanner) ScanTokens() error {
+	for !s.AtEnd() {
 		s.start = s.current
+		s.column = s.start - s.lineStart + 1
 		s.scanToken()
-		if s.AtEnd() {
-			break
-		}
 	}
+	s.start = s.current
+	s.column = s.start - s.lineStart + 1
 	s.addToken(EOF)
+	return s.errors.Err()
 }
 
 func (s *Scanner) scanToken() {
@@ -134,6 +171,12 @@ func (s *Scanner) scanToken() {
 		s.addToken(LeftBrace)
 	case '}':
 		s.addToken(RightBrace)
+	case '[':
+		s.addToken(LeftBracket)
+	case ']':
+		s.addToken(RightBracket)
+	case ':':
+		s.addToken(COLON)
 	case ',':
 		s.addToken(COMMA)
 	case '.':
@@ -149,7 +192,11 @@ func (s *Scanner) scanToken() {
 	case '!':
 		s.addTokenWithDual(s.match('='), BangEqual, BANG)
 	case '=':
-		s.addTokenWithDual(s.match('='), EqualEqual, EQUAL)
+		if s.match('>') {
+			s.addToken(ARROW)
+		} else {
+			s.addTokenWithDual(s.match('='), EqualEqual, EQUAL)
+		}
 	case '<':
 		s.addTokenWithDual(s.match('='), LessEqual, LESS)
 	case '>':
@@ -164,6 +211,11 @@ func (s *Scanner) scanToken() {
 					s.advance()
 				}
 			}
+			comment := NewToken(COMMENT, strings.TrimRight(s.Source[s.start:s.current], " \t\r"), nil, s.line)
+			comment.Column = s.column
+			comment.Offset = s.start
+			comment.File = s.File
+			s.Comments = append(s.Comments, comment)
 		} else {
 			s.addToken(SLASH)
 		}
@@ -172,8 +224,7 @@ func (s *Scanner) scanToken() {
 	case '\t':
 		// Ignore whitespace.
 	case '\n':
-		s.line++
-		break
+		s.newline()
 	case '"':
 		s.string()
 	default:
@@ -195,8 +246,23 @@ func (s *Scanner) advance() uint8 {
 }
 
 func (s *Scanner) addToken(tt TokenType) {
-	text := s.Source[s.start:s.current]
-	s.Tokens = append(s.Tokens, NewToken(tt, text, nil, s.line))
+	s.addTokenAt(tt, nil, s.line)
+}
+
+// addTokenAt adds a token for the current lexeme that starts on line, at the
+// column recorded when the lexeme began.
+func (s *Scanner) addTokenAt(tt TokenType, literal interface{}, line int) {
+	token := NewToken(tt, s.Source[s.start:s.current], literal, line)
+	token.Column = s.column
+	token.Offset = s.start
+	token.File = s.File
+	s.Tokens = append(s.Tokens, token)
+}
+
+// newline records that the character just consumed ended a line.
+func (s *Scanner) newline() {
+	s.line++
+	s.lineStart = s.current
 }
 
 func (s *Scanner) addTokenWithDual(match bool, first TokenType, second TokenType) {
@@ -208,8 +274,7 @@ func (s *Scanner) addTokenWithDual(match bool, first TokenType, second TokenType
 }
 
 func (s *Scanner) addTokenWithLiteral(tt TokenType, literal interface{}) {
-	text := s.Source[s.start:s.current]
-	s.Tokens = append(s.Tokens, NewToken(tt, text, literal, s.line))
+	s.addTokenAt(tt, literal, s.line)
 }
 
 func (s *Scanner) match(expected int32) bool {
@@ -228,23 +293,88 @@ func (s *Scanner) AtEnd() bool {
 	return s.current >= len(s.Source)
 }
 
+// error records a scan error at the character just consumed.
 func (s *Scanner) error(line int, msg string) {
-	log.Fatalf("Error at line %d : %s\n", line, msg)
+	s.errorAt(line, s.current-s.lineStart, msg)
+}
+
+func (s *Scanner) errorAt(line int, column int, msg string) {
+	s.errors = append(s.errors, &ScanError{Line: line, Column: column, Message: msg})
 }
 
+// string scans a string literal. Strings may span lines; the token carries
+// the line the literal starts on.
 func (s *Scanner) string() {
+	line := s.line
+	var value strings.Builder
 	for s.peek() != '"' && !s.AtEnd() {
-		if s.peek() == '\n' {
-			s.line++
+		c := s.advance()
+		switch c {
+		case '\n':
+			s.newline()
+			value.WriteByte(c)
+		case '\\':
+			s.escape(&value)
+		default:
+			value.WriteByte(c)
 		}
-		s.advance()
 	}
 	if s.AtEnd() {
-		s.error(s.line, "Unterminated string..")
+		s.errorAt(line, s.column, "Unterminated string.")
+		return
 	}
 	s.advance()
-	value := s.Source[s.start+1 : s.current-1]
-	s.addTokenWithLiteral(STRING, value)
+	s.addTokenAt(STRING, value.String(), line)
+}
+
+// escape decodes the escape sequence following a backslash.
+func (s *Scanner) escape(value *strings.Builder) {
+	if s.AtEnd() {
+		return
+	}
+	c := s.advance()
+	switch c {
+	case 'n':
+		value.WriteByte('\n')
+	case 't':
+		value.WriteByte('\t')
+	case 'r':
+		value.WriteByte('\r')
+	case '0':
+		value.WriteByte(0)
+	case '"', '\\':
+		value.WriteByte(c)
+	case 'u':
+		s.unicodeEscape(value)
+	default:
+		if c == '\n' {
+			s.newline()
+		}
+		s.error(s.line, "Invalid escape sequence '\\"+string(c)+"'.")
+	}
+}
+
+// unicodeEscape decodes \u{XXXX}: one to six hex digits naming a code point.
+func (s *Scanner) unicodeEscape(value *strings.Builder) {
+	if !s.match('{') {
+		s.error(s.line, "Expected '{' after '\\u'.")
+		return
+	}
+	start := s.current
+	for s.isHexDigit(s.peek()) {
+		s.advance()
+	}
+	digits := s.Source[start:s.current]
+	if !s.match('}') || len(digits) == 0 || len(digits) > 6 {
+		s.error(s.line, "Invalid unicode escape sequence.")
+		return
+	}
+	code, _ := strconv.ParseUint(digits, 16, 32)
+	if !utf8.ValidRune(rune(code)) {
+		s.error(s.line, "Invalid unicode code point '"+digits+"'.")
+		return
+	}
+	value.WriteRune(rune(code))
 }
 
 func (s *Scanner) number() {
@@ -284,6 +414,10 @@ func (s *Scanner) IsDigit(c uint8) bool {
 	return c >= '0' && c <= '9'
 }
 
+func (s *Scanner) isHexDigit(c uint8) bool {
+	return s.IsDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
 func (s *Scanner) IsAlpha(c uint8) bool {
 	return (c >= 'a' && c <= 'z') ||
 		(c >= 'A' && c <= 'Z') ||
@@ -307,16 +441,19 @@ func (s *Scanner) identifier() {
 	}
 }
 
-func (s *Scanner) Eval(expr string) {
+func (s *Scanner) Eval(expr string) error {
 	s.reset()
 	s.Source = expr
-	s.ScanTokens()
+	return s.ScanTokens()
 }
 
 func (s *Scanner) reset() {
 	s.current = 0
 	s.start = 0
 	s.line = 1
+	s.lineStart = 0
 	s.Source = ""
 	s.Tokens = nil
+	s.Comments = nil
+	s.errors = nil
 }
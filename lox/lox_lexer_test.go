@@ -0,0 +1,47 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestScanner_Escapes(t *testing.T) {
+	lexer := NewScanner()
+	if err := lexer.Eval(`"tab\there\n\"quoted\" back\\slash \u{48}\u{e9}\u{1F600}"`); err != nil {
+		t.Fatal(err)
+	}
+	want := "tab\there\n\"quoted\" back\\slash Hé\U0001F600"
+	if got := lexer.Tokens[0].Literal; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestScanner_BadEscapes(t *testing.T) {
+	for _, src := range []string{`"\q"`, `"\u41"`, `"\u{}"`, `"\u{110000}"`, `"\u{1234567}"`} {
+		lexer := NewScanner()
+		if err := lexer.Eval(src); err == nil {
+			t.Errorf("expected an error scanning %s", src)
+		}
+	}
+}
+
+func TestScanner_MultilineString(t *testing.T) {
+	lexer := NewScanner()
+	if err := lexer.Eval("var s = \"one\ntwo\nthree\";\nprint s;"); err != nil {
+		t.Fatal(err)
+	}
+	str := lexer.Tokens[3]
+	if str.Literal != "one\ntwo\nthree" || str.Line != 1 {
+		t.Fatalf("unexpected string token %+v", str)
+	}
+	if print := lexer.Tokens[5]; print.TokenType != PRINT || print.Line != 4 || print.Column != 1 {
+		t.Fatalf("expected print at line 4, column 1, got %+v", print)
+	}
+	if str.Column != 9 {
+		t.Fatalf("expected the string to start at column 9, got %d", str.Column)
+	}
+
+	err := lexer.Eval("print 1;\nprint \"open\n\n")
+	if serr := err.(ErrorList)[0].(*ScanError); serr.Line != 2 {
+		t.Fatalf("expected the unterminated string on line 2, got %v", serr)
+	}
+}
@@ -0,0 +1,179 @@
+package lox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoxModule is the value import "path" as name; binds: the exports of a
+// module, read as properties. Exports are the globals the module declares
+// with var, fun or class, except those whose name starts with "_", as they
+// were when the module finished running.
+type LoxModule struct {
+	name    string
+	path    string
+	exports map[string]Value
+}
+
+// Get returns the export called name.
+func (m *LoxModule) Get(name string) (Value, bool) {
+	value, ok := m.exports[name]
+	return value, ok
+}
+
+// Names returns the names of the exports in alphabetical order.
+func (m *LoxModule) Names() []string {
+	names := make([]string, 0, len(m.exports))
+	for name := range m.exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *LoxModule) String() string {
+	return "<module " + m.name + ">"
+}
+
+// ImportError reports a module that could not be loaded because it was not
+// found, could not be read, or did not scan, parse or compile.
+type ImportError struct {
+	Path string
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("Can't import \"%s\": %v", e.Path, e.Err)
+}
+
+// moduleLoader finds and loads the modules of one backend. Each module runs
+// the first time it is imported; later imports share the result.
+type moduleLoader struct {
+	searchPath []string
+	modules    map[string]*LoxModule
+	// loading holds the files of the imports being run, innermost last, to
+	// detect cycles.
+	loading []string
+}
+
+// moduleRunner runs the statements of a module in fresh globals and returns
+// a function reading those globals.
+type moduleRunner func(statements []Stmt) (func(name string) Value, error)
+
+func newModuleLoader() *moduleLoader {
+	return &moduleLoader{modules: make(map[string]*LoxModule)}
+}
+
+// load returns the module at path, imported by a statement in the file
+// importer. A module that fails to load is not cached. Runtime errors and
+// uncaught exceptions of the module are returned unchanged, anything else
+// as an *ImportError.
+func (m *moduleLoader) load(importer string, path string, run moduleRunner) (*LoxModule, error) {
+	file, err := m.find(importer, path)
+	if err != nil {
+		return nil, &ImportError{Path: path, Err: err}
+	}
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return nil, &ImportError{Path: path, Err: err}
+	}
+	if module, ok := m.modules[key]; ok {
+		return module, nil
+	}
+	for idx, loading := range m.loading {
+		if loading == key {
+			cycle := append(append([]string(nil), m.loading[idx:]...), key)
+			for i := range cycle {
+				cycle[i] = filepath.Base(cycle[i])
+			}
+			return nil, &ImportError{Path: path, Err: fmt.Errorf("import cycle %s", strings.Join(cycle, " -> "))}
+		}
+	}
+
+	statements, err := parseModule(file)
+	if err != nil {
+		return nil, &ImportError{Path: path, Err: err}
+	}
+	m.loading = append(m.loading, key)
+	defer func() { m.loading = m.loading[:len(m.loading)-1] }()
+	lookup, err := run(statements)
+	switch err.(type) {
+	case nil:
+	case *RuntimeError, *thrownValue, *LimitError:
+		return nil, err
+	default:
+		return nil, &ImportError{Path: path, Err: err}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	module := &LoxModule{name: name, path: file, exports: make(map[string]Value)}
+	for _, export := range exportedNames(statements) {
+		module.exports[export] = lookup(export)
+	}
+	m.modules[key] = module
+	return module, nil
+}
+
+// find locates path: as given when it is absolute, otherwise relative to
+// the directory of the importing file, or of the working directory when the
+// importer has no file, and then relative to each directory of the search
+// path in turn.
+func (m *moduleLoader) find(importer string, path string) (string, error) {
+	var candidates []string
+	if filepath.IsAbs(path) {
+		candidates = []string{path}
+	} else {
+		candidates = append(candidates, filepath.Join(filepath.Dir(importer), path))
+		for _, dir := range m.searchPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("module not found")
+}
+
+// parseModule reads, scans and parses the module in file. Tokens carry file
+// as their File.
+func parseModule(file string) ([]Stmt, error) {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	scanner := NewScanner()
+	scanner.File = file
+	if err := scanner.Eval(string(source)); err != nil {
+		return nil, err
+	}
+	return NewParser(scanner.Tokens).Parse()
+}
+
+// exportedNames returns the names of the globals the module statements
+// declare, without the private ones starting with "_".
+func exportedNames(statements []Stmt) []string {
+	var names []string
+	for _, stmt := range statements {
+		var name Token
+		switch s := stmt.(type) {
+		case *VariableStmt:
+			name = s.name
+		case *FunctionStmt:
+			name = s.name
+		case *ClassStmt:
+			name = s.name
+		default:
+			continue
+		}
+		if !strings.HasPrefix(name.Lexeme, "_") {
+			names = append(names, name.Lexeme)
+		}
+	}
+	return names
+}
@@ -0,0 +1,96 @@
+package lox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModules_SearchPath(t *testing.T) {
+	prog := `import "geometry.lox" as g;
+	var result = twice(g.Point(1, 2).sum());`
+	for name, kind := range backends {
+		var out bytes.Buffer
+		backend := NewBackendWithOutput(kind, &out, ioutil.Discard)
+		backend.DefineNative("twice", 1, func(args []Value) (Value, error) {
+			return args[0].(float64) * 2, nil
+		})
+		if err := backend.Interpret(parse(t, prog)); err == nil {
+			t.Fatalf("%s: expected geometry.lox not to be found outside the search path", name)
+		}
+
+		backend.SetSearchPath("testdata/nowhere", "testdata/modules")
+		if err := backend.Interpret(parse(t, prog)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result, _ := backend.Get("result"); result != 6.0 {
+			t.Errorf("%s: expected 6, got %v", name, result)
+		}
+		// Importing again uses the module already loaded.
+		if err := backend.Interpret(parse(t, `import "geometry.lox";`)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := out.String(); got != "loading geometry\n" {
+			t.Errorf("%s: expected the module to run once, got %q", name, got)
+		}
+	}
+}
+
+func TestModules_Errors(t *testing.T) {
+	progs := []struct {
+		prog string
+		msg  string
+		line int
+	}{
+		{`import "broken.lox";`, "Can't import \"broken.lox\": [line 1] Error at '=': Expect variable name.", 1},
+		{"var a;\nimport \"fails.lox\";", "Operand must be a number.", 2},
+		{`import "geometry.lox" as g; g.origin = 1;`, "Only instances have fields.", 1},
+	}
+	for name, kind := range backends {
+		for _, tc := range progs {
+			backend := NewBackendWithOutput(kind, ioutil.Discard, ioutil.Discard)
+			backend.SetSearchPath("testdata/modules")
+			err := backend.Interpret(parse(t, tc.prog))
+			rerr, ok := err.(*RuntimeError)
+			if !ok || rerr.Message != tc.msg || rerr.Line != tc.line {
+				t.Errorf("%s: %s: expected %q on line %d, got %v", name, tc.prog, tc.msg, tc.line, err)
+				continue
+			}
+			// A failed import leaves the backend able to run programs.
+			if err := backend.Interpret(parse(t, `var after = [1].map(str);`)); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestModules_StackTrace(t *testing.T) {
+	want := []string{"[line 2] in divide()", "[line 4] in script", "[line 1] in script"}
+	for name, kind := range backends {
+		backend := NewBackendWithOutput(kind, ioutil.Discard, ioutil.Discard)
+		backend.SetSearchPath("testdata/modules")
+		err := backend.Interpret(parse(t, `import "fails_in_function.lox";`))
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("%s: expected *RuntimeError, got %v", name, err)
+		}
+		if !reflect.DeepEqual(rerr.Stack, want) {
+			t.Errorf("%s: unexpected stack trace %q", name, rerr.Stack)
+		}
+	}
+}
+
+func TestModules_Disassemble(t *testing.T) {
+	fn, err := NewCompiler().Compile(parse(t, `import "a.lox" as a; import "b.lox";`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := fn.chunk.Disassemble("imports")
+	for _, want := range []string{"OP_IMPORT           0 'a.lox'", "OP_DEFINE_GLOBAL", "OP_IMPORT_ALL"} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("expected %q in\n%s", want, listing)
+		}
+	}
+}
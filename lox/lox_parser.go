@@ -1,12 +1,11 @@
 package lox
 
-import (
-	"log"
-)
-
 type Parser struct {
 	tokens  []Token
 	current int
+	errors  ErrorList
+	// braces counts the braces consumed so far that are not yet closed.
+	braces int
 }
 
 func NewParser(tokens []Token) *Parser {
@@ -17,30 +16,53 @@ func NewParser(tokens []Token) *Parser {
 }
 
 //Parse ::program        → declaration* EOF
-func (p *Parser) Parse() []Stmt {
+//
+// Parse reports every syntax error it finds. After an error it skips to the
+// next statement boundary and carries on, so along with the error it returns
+// the declarations that did parse.
+func (p *Parser) Parse() ([]Stmt, error) {
 	var statements []Stmt
 	for !p.isAtEnd() {
-		statements = append(statements, p.declaration())
+		if stmt := p.declaration(); stmt != nil {
+			statements = append(statements, stmt)
+		}
 	}
-	return statements
+	return statements, p.errors.Err()
 }
 
-//declaration    → classDecl | funDecl | varDecl | statement
-func (p *Parser) declaration() Stmt {
+//declaration    → classDecl | funDecl | varDecl | importDecl | statement
+//
+// declaration returns nil when the declaration has a syntax error that
+// stopped the parser; the error is recorded and the tokens up to the next
+// statement boundary are skipped.
+func (p *Parser) declaration() (stmt Stmt) {
+	braces := p.braces
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*ParseError); !ok {
+				panic(r)
+			}
+			p.synchronize(p.braces-braces, braces > 0)
+			stmt = nil
+		}
+	}()
 
+	start := p.peek()
 	if p.match(CLASS) {
-		return p.classDeclaration()
-	}
-
-	if p.match(FUN) {
-		return p.function("function")
-	}
-	if p.match(VAR) {
-		return p.varDeclaration()
+		stmt = p.classDeclaration()
+	} else if p.check(FUN) && p.lookahead(1).TokenType != LeftParen {
+		// "fun (" starts an anonymous function in an expression statement.
+		p.advance()
+		stmt = p.function("function")
+	} else if p.match(VAR) {
+		stmt = p.varDeclaration()
+	} else if p.match(IMPORT) {
+		stmt = p.importDeclaration()
+	} else {
+		return p.statement()
 	}
-	return p.statement()
-	//TODO implement error handling and return error
-	//synchronise it here if something goes wrong
+	p.finish(stmt, start)
+	return stmt
 }
 
 //classDecl      → "class" IDENTIFIER ( "<" IDENTIFIER )? "{" function* "}" ;
@@ -52,6 +74,7 @@ func (p *Parser) classDeclaration() Stmt {
 	if p.match(LESS) {
 		p.consume(IDENTIFIER, "Expect superclass name.")
 		superclass = NewVariableExpr(p.previous())
+		p.finish(superclass, p.previous())
 	}
 
 	p.consume(LeftBrace, "Expected '{' before class body.")
@@ -68,8 +91,20 @@ func (p *Parser) classDeclaration() Stmt {
 func (p *Parser) function(kind string) Stmt {
 	name := p.consume(IDENTIFIER, "Expect "+kind+" name.")
 	p.consume(LeftParen, "Expect '(' after "+kind+" name.")
-	var parameters []Token
+	parameters := p.parameters()
+	p.consume(LeftBrace, "Expect '{' before "+kind+" body.")
+	body := p.block()
+	fn := NewFunctionStmt(name, parameters, body)
+	p.finish(fn, name)
+	return fn
 
+}
+
+//parameters     → IDENTIFIER ( "," IDENTIFIER )* ;
+//
+// parameters parses the parameter list after "(" and the closing ")".
+func (p *Parser) parameters() []Token {
+	var parameters []Token
 	if !p.check(RightParen) {
 		for {
 			if len(parameters) > 255 {
@@ -81,15 +116,54 @@ func (p *Parser) function(kind string) Stmt {
 			}
 		}
 	}
-
 	p.consume(RightParen, "Expect ')' after parameters.")
-	p.consume(LeftBrace, "Expect '{' before "+kind+" body.")
-	body := p.block()
-	return NewFunctionStmt(name, parameters, body)
+	return parameters
+}
 
+//arrow          → ( IDENTIFIER | "(" parameters? ")" ) "=>" ( block | expression ) ;
+//
+// A body starting with "{" is a block, so an arrow returning a map literal
+// must put it in parentheses.
+func (p *Parser) arrowFunction() Expr {
+	keyword := p.peek()
+	var parameters []Token
+	if p.match(IDENTIFIER) {
+		parameters = []Token{p.previous()}
+	} else {
+		p.consume(LeftParen, "Expect '(' before parameters.")
+		parameters = p.parameters()
+	}
+	arrow := p.consume(ARROW, "Expect '=>' after parameters.")
+	if p.match(LeftBrace) {
+		return NewFunctionExpr(keyword, parameters, p.block(), true, false)
+	}
+	value := p.expression()
+	ret := NewReturnStmt(arrow, value)
+	setSpan(ret, value.Span())
+	return NewFunctionExpr(keyword, parameters, []Stmt{ret}, true, true)
 }
 
-//parameters     → IDENTIFIER ( "," IDENTIFIER )* ;
+// arrowAhead reports whether the next tokens start an arrow function: a
+// name followed by "=>", or a parenthesized list of names followed by "=>".
+func (p *Parser) arrowAhead() bool {
+	if p.check(IDENTIFIER) {
+		return p.lookahead(1).TokenType == ARROW
+	}
+	if !p.check(LeftParen) {
+		return false
+	}
+	n := 1
+	if p.lookahead(n).TokenType != RightParen {
+		for p.lookahead(n).TokenType == IDENTIFIER && p.lookahead(n+1).TokenType == COMMA {
+			n += 2
+		}
+		if p.lookahead(n).TokenType != IDENTIFIER || p.lookahead(n+1).TokenType != RightParen {
+			return false
+		}
+		n++
+	}
+	return p.lookahead(n+1).TokenType == ARROW
+}
 
 //varDecl        → "var" IDENTIFIER ( "=" expression )? ";" ;
 func (p *Parser) varDeclaration() Stmt {
@@ -104,31 +178,64 @@ func (p *Parser) varDeclaration() Stmt {
 	return NewVariableStmt(name, expr)
 }
 
-//statement      → exprStmt | forStmt | ifStmt | printStmt | returnStmt | whileStmt | block
-func (p *Parser) statement() Stmt {
+//importDecl     → "import" STRING ( "as" IDENTIFIER )? ";" ;
+//
+// Like "in", "as" is only special in this position.
+func (p *Parser) importDeclaration() Stmt {
+	keyword := p.previous()
+	path := p.consume(STRING, "Expect module path after 'import'.")
+	var alias *Token
+	if p.check(IDENTIFIER) && p.peek().Lexeme == "as" {
+		p.advance()
+		name := p.consume(IDENTIFIER, "Expect module name after 'as'.")
+		alias = &name
+	}
+	p.consume(SEMICOLON, "Expect ';' after import.")
+	return NewImportStmt(keyword, path, alias)
+}
 
+//statement      → exprStmt | forStmt | ifStmt | printStmt | returnStmt | whileStmt
+//                 | breakStmt | continueStmt | throwStmt | tryStmt | block
+func (p *Parser) statement() Stmt {
+	start := p.peek()
+	var stmt Stmt
 	if p.match(FOR) {
-		return p.forStatement()
-	}
-	if p.match(IF) {
-		return p.ifStatement()
-	}
-	if p.match(PRINT) {
-		return p.printStatement()
-	}
-	if p.match(RETURN) {
-		return p.returnStatement()
-	}
-	if p.match(WHILE) {
-		return p.whileStatement()
-	}
-	if p.match(LeftBrace) {
-		return NewBlockStmt(p.block())
+		stmt = p.forStatement()
+	} else if p.match(IF) {
+		stmt = p.ifStatement()
+	} else if p.match(PRINT) {
+		stmt = p.printStatement()
+	} else if p.match(RETURN) {
+		stmt = p.returnStatement()
+	} else if p.match(WHILE) {
+		stmt = p.whileStatement()
+	} else if p.match(BREAK) {
+		keyword := p.previous()
+		p.consume(SEMICOLON, "Expected ';' after 'break'.")
+		stmt = NewBreakStmt(keyword)
+	} else if p.match(CONTINUE) {
+		keyword := p.previous()
+		p.consume(SEMICOLON, "Expected ';' after 'continue'.")
+		stmt = NewContinueStmt(keyword)
+	} else if p.match(THROW) {
+		keyword := p.previous()
+		value := p.expression()
+		p.consume(SEMICOLON, "Expected ';' after thrown value.")
+		stmt = NewThrowStmt(keyword, value)
+	} else if p.match(TRY) {
+		stmt = p.tryStatement()
+	} else if p.match(LeftBrace) {
+		stmt = NewBlockStmt(p.block())
+	} else {
+		stmt = p.expressionStatement()
 	}
-
-	return p.expressionStatement()
+	p.finish(stmt, start)
+	return stmt
 }
 
+//breakStmt      → "break" ";" ;
+//continueStmt   → "continue" ";" ;
+//throwStmt      → "throw" expression ";" ;
 //returnStmt     → "return" expression? ";" ;
 func (p *Parser) returnStatement() Stmt {
 	keyword := p.previous()
@@ -140,6 +247,37 @@ func (p *Parser) returnStatement() Stmt {
 	return NewReturnStmt(keyword, value)
 }
 
+//tryStmt        → "try" block ( "catch" "(" IDENTIFIER ")" block )? ( "finally" block )? ;
+//
+// A try statement needs a catch clause, a finally clause or both.
+func (p *Parser) tryStatement() Stmt {
+	keyword := p.previous()
+	body := p.clauseBlock("try")
+	var name Token
+	var catchBody, finallyBody *BlockStmt
+	if p.match(CATCH) {
+		p.consume(LeftParen, "Expect '(' after 'catch'.")
+		name = p.consume(IDENTIFIER, "Expect error variable name.")
+		p.consume(RightParen, "Expect ')' after error variable.")
+		catchBody = p.clauseBlock("catch clause")
+	}
+	if p.match(FINALLY) {
+		finallyBody = p.clauseBlock("finally")
+	}
+	if catchBody == nil && finallyBody == nil {
+		p.error(p.peek(), "Expect 'catch' or 'finally' after try block.")
+	}
+	return NewTryStmt(keyword, body, name, catchBody, finallyBody)
+}
+
+// clauseBlock parses the block of a clause of a try statement.
+func (p *Parser) clauseBlock(after string) *BlockStmt {
+	start := p.consume(LeftBrace, "Expect '{' after "+after+".")
+	block := NewBlockStmt(p.block())
+	p.finish(block, start)
+	return block.(*BlockStmt)
+}
+
 //whileStmt      → "while" "(" expression ")" statement ;
 func (p *Parser) whileStatement() Stmt {
 	p.consume(LeftParen, "Expected '(' after 'while'.)")
@@ -150,9 +288,22 @@ func (p *Parser) whileStatement() Stmt {
 
 //forStmt        → "for" "(" ( varDecl | exprStmt | ";" )
 //                 expression? ";"
-//                 expression? ")" statement ;
+//                 expression? ")" statement
+//                 | "for" "(" "var" IDENTIFIER "in" expression ")" statement ;
+//
+// "in" is only special in this position, so it is still a valid name.
 func (p *Parser) forStatement() Stmt {
 	p.consume(LeftParen, "Expected '(' after 'for'.")
+	if p.check(VAR) && p.lookahead(1).TokenType == IDENTIFIER &&
+		p.lookahead(2).TokenType == IDENTIFIER && p.lookahead(2).Lexeme == "in" {
+		p.advance()
+		name := p.advance()
+		p.advance()
+		iterable := p.expression()
+		p.consume(RightParen, "Expect ')' after for clauses.")
+		return NewForInStmt(name, iterable, p.statement())
+	}
+
 	var initializer Stmt
 	if p.match(SEMICOLON) {
 		initializer = nil
@@ -175,23 +326,7 @@ func (p *Parser) forStatement() Stmt {
 	}
 	p.consume(RightParen, "Expect ')' after for clauses.")
 	body := p.statement()
-
-	//desugar to while loop
-	if increment != nil {
-		body = NewBlockStmt([]Stmt{body, NewExprStmt(increment)})
-	}
-
-	if condition == nil {
-		condition = NewLiteralExpr(true)
-	}
-
-	body = NewWhileStmt(condition, body)
-
-	if initializer != nil {
-		body = NewBlockStmt([]Stmt{initializer, body})
-	}
-
-	return body
+	return NewForStmt(initializer, condition, increment, body)
 }
 
 //ifStmt         → "if" "(" expression ")" statement ( "else" statement )? ;
@@ -213,8 +348,9 @@ func (p *Parser) ifStatement() Stmt {
 func (p *Parser) block() []Stmt {
 	var statements []Stmt
 	for !p.check(RightBrace) && !p.isAtEnd() {
-		stmt := p.declaration()
-		statements = append(statements, stmt)
+		if stmt := p.declaration(); stmt != nil {
+			statements = append(statements, stmt)
+		}
 	}
 	p.consume(RightBrace, "Expect '}' after block.")
 	return statements
@@ -237,7 +373,8 @@ func (p *Parser) expression() Expr {
 	return p.assignment()
 }
 
-//assignment     → ( call "." )? IDENTIFIER "=" assignment | logic_or ;
+//assignment     → ( call "." IDENTIFIER | call "[" expression "]" | IDENTIFIER ) "=" assignment
+//                 | logic_or ;
 func (p *Parser) assignment() Expr {
 
 	expr := p.or()
@@ -249,13 +386,17 @@ func (p *Parser) assignment() Expr {
 		valexpr, ok := expr.(*VariableExpr)
 
 		if ok {
-			return NewAssignExpr(valexpr.name, value)
+			return p.join(NewAssignExpr(valexpr.name, value), expr, value)
 		}
 
 		getexpr, ok := expr.(*GetExpr)
 
 		if ok {
-			return NewSetExpr(getexpr.object, getexpr.name, value)
+			return p.join(NewSetExpr(getexpr.object, getexpr.name, value), expr, value)
+		}
+
+		if index, ok := expr.(*IndexExpr); ok {
+			return p.join(NewSetIndexExpr(index.object, index.bracket, index.index, value), expr, value)
 		}
 
 		p.error(equals, "Invalid assignment target.")
@@ -270,7 +411,7 @@ func (p *Parser) or() Expr {
 	for p.match(OR) {
 		operator := p.previous()
 		right := p.and()
-		expr = NewLogicalExpr(expr, operator, right)
+		expr = p.join(NewLogicalExpr(expr, operator, right), expr, right)
 	}
 	return expr
 }
@@ -282,7 +423,7 @@ func (p *Parser) and() Expr {
 	for p.match(AND) {
 		operator := p.previous()
 		right := p.equality()
-		expr = NewLogicalExpr(expr, operator, right)
+		expr = p.join(NewLogicalExpr(expr, operator, right), expr, right)
 	}
 	return expr
 }
@@ -293,7 +434,7 @@ func (p *Parser) equality() Expr {
 	for p.match(BangEqual, EqualEqual) {
 		operator := p.previous()
 		right := p.comparison()
-		expr = NewBinaryExpr(expr, operator, right)
+		expr = p.join(NewBinaryExpr(expr, operator, right), expr, right)
 	}
 	return expr
 }
@@ -304,7 +445,7 @@ func (p *Parser) comparison() Expr {
 	for p.match(GREATER, GreaterEqual, LESS, LessEqual) {
 		operator := p.previous()
 		right := p.term()
-		expr = NewBinaryExpr(expr, operator, right)
+		expr = p.join(NewBinaryExpr(expr, operator, right), expr, right)
 	}
 	return expr
 }
@@ -315,7 +456,7 @@ func (p *Parser) term() Expr {
 	for p.match(MINUS, PLUS) {
 		operator := p.previous()
 		right := p.factor()
-		expr = NewBinaryExpr(expr, operator, right)
+		expr = p.join(NewBinaryExpr(expr, operator, right), expr, right)
 	}
 	return expr
 }
@@ -326,7 +467,7 @@ func (p *Parser) factor() Expr {
 	for p.match(SLASH, STAR) {
 		operator := p.previous()
 		right := p.unary()
-		expr = NewBinaryExpr(expr, operator, right)
+		expr = p.join(NewBinaryExpr(expr, operator, right), expr, right)
 	}
 	return expr
 }
@@ -337,13 +478,15 @@ func (p *Parser) unary() Expr {
 	if p.match(BANG, MINUS) {
 		operator := p.previous()
 		right := p.unary()
-		return NewUnaryExpr(operator, right)
+		unary := NewUnaryExpr(operator, right)
+		setSpan(unary, operator.Span().Join(right.Span()))
+		return unary
 	}
 
 	return p.call()
 }
 
-//call           → primary ( "(" arguments? ")"  | "." IDENTIFIER )* ;
+//call           → primary ( "(" arguments? ")"  | "." IDENTIFIER | "[" expression "]" )* ;
 func (p *Parser) call() Expr {
 	expr := p.primary()
 	for {
@@ -351,7 +494,15 @@ func (p *Parser) call() Expr {
 			expr = p.finishCall(expr)
 		} else if p.match(DOT) {
 			name := p.consume(IDENTIFIER, "Expect property name after '.'.")
-			expr = NewGetExpr(expr, name)
+			get := NewGetExpr(expr, name)
+			setSpan(get, expr.Span().Join(name.Span()))
+			expr = get
+		} else if p.match(LeftBracket) {
+			bracket := p.previous()
+			index := NewIndexExpr(expr, bracket, p.expression())
+			closing := p.consume(RightBracket, "Expect ']' after index.")
+			setSpan(index, expr.Span().Join(closing.Span()))
+			expr = index
 		} else {
 			break
 		}
@@ -374,13 +525,26 @@ func (p *Parser) finishCall(callee Expr) Expr {
 		}
 	}
 	paren := p.consume(RightParen, "Expected ')' after arguments.")
-	return NewCallExpr(callee, paren, arguments)
+	call := NewCallExpr(callee, paren, arguments)
+	setSpan(call, callee.Span().Join(paren.Span()))
+	return call
 }
 
 //arguments      → expression ( "," expression )* ;
 
-//primary        →  "true" | "false" | "nil" | NUMBER | STRING | "this" | IDENTIFIER | "(" expression ") | "super" "." IDENTIFIER ;;
+//primary        →  "true" | "false" | "nil" | NUMBER | STRING | "this" | IDENTIFIER | "(" expression ") | "super" "." IDENTIFIER
+//                 | "[" ( expression ( "," expression )* )? "]"
+//                 | "{" ( entry ( "," entry )* )? "}"
+//                 | "fun" "(" parameters? ")" block | arrow ;
+//entry          → expression ":" expression ;
 func (p *Parser) primary() Expr {
+	start := p.peek()
+	expr := p.primaryExpr()
+	p.finish(expr, start)
+	return expr
+}
+
+func (p *Parser) primaryExpr() Expr {
 
 	if p.match(TRUE) {
 		return NewLiteralExpr(true)
@@ -402,10 +566,22 @@ func (p *Parser) primary() Expr {
 		return NewThisExpr(p.previous())
 	}
 
+	if p.arrowAhead() {
+		return p.arrowFunction()
+	}
+
 	if p.match(IDENTIFIER) {
 		return NewVariableExpr(p.previous())
 	}
 
+	if p.match(FUN) {
+		keyword := p.previous()
+		p.consume(LeftParen, "Expect '(' after 'fun'.")
+		parameters := p.parameters()
+		p.consume(LeftBrace, "Expect '{' before function body.")
+		return NewFunctionExpr(keyword, parameters, p.block(), false, false)
+	}
+
 	if p.match(LeftParen) {
 		expr := p.expression()
 		p.consume(RightParen, "Expecting ) after expression")
@@ -419,8 +595,52 @@ func (p *Parser) primary() Expr {
 		return NewSuperExpr(keyword, method)
 	}
 
-	p.error(p.peek(), "Expected expression.")
-	return nil
+	if p.match(LeftBracket) {
+		bracket := p.previous()
+		var elements []Expr
+		if !p.check(RightBracket) {
+			for {
+				elements = append(elements, p.expression())
+				if !p.match(COMMA) {
+					break
+				}
+			}
+		}
+		p.consume(RightBracket, "Expect ']' after list elements.")
+		return NewListExpr(bracket, elements)
+	}
+
+	if p.match(LeftBrace) {
+		brace := p.previous()
+		var keys, values []Expr
+		if !p.check(RightBrace) {
+			for {
+				keys = append(keys, p.expression())
+				p.consume(COLON, "Expect ':' after map key.")
+				values = append(values, p.expression())
+				if !p.match(COMMA) {
+					break
+				}
+			}
+		}
+		p.consume(RightBrace, "Expect '}' after map entries.")
+		return NewMapExpr(brace, keys, values)
+	}
+
+	panic(p.error(p.peek(), "Expected expression."))
+}
+
+// finish sets the span of an Expr or Stmt to run from start to the last
+// consumed token.
+func (p *Parser) finish(n interface{}, start Token) {
+	setSpan(n, start.Span().Join(p.previous().Span()))
+}
+
+// join sets the span of expr to run from the start of first to the end of
+// last, and returns expr.
+func (p *Parser) join(expr Expr, first Expr, last Expr) Expr {
+	setSpan(expr, first.Span().Join(last.Span()))
+	return expr
 }
 
 func (p *Parser) match(types ...TokenType) bool {
@@ -443,6 +663,12 @@ func (p *Parser) check(typ TokenType) bool {
 func (p *Parser) advance() Token {
 	if !p.isAtEnd() {
 		p.current++
+		switch p.previous().TokenType {
+		case LeftBrace:
+			p.braces++
+		case RightBrace:
+			p.braces--
+		}
 	}
 	return p.previous()
 }
@@ -455,6 +681,15 @@ func (p *Parser) peek() Token {
 	return p.tokens[p.current]
 }
 
+// lookahead returns the token n places after the current one, or EOF past
+// the end.
+func (p *Parser) lookahead(n int) Token {
+	if p.current+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.current+n]
+}
+
 func (p *Parser) previous() Token {
 	return p.tokens[p.current-1]
 }
@@ -463,47 +698,51 @@ func (p *Parser) consume(tokenType TokenType, msg string) Token {
 	if p.check(tokenType) {
 		return p.advance()
 	}
-	p.error(p.peek(), msg)
-	return NewErrorToken()
+	panic(p.error(p.peek(), msg))
 }
 
-func (p *Parser) error(token Token, msg string) {
-	if token.TokenType == EOF {
-		p.report(token.Line, " at end", msg)
-	} else {
-		p.report(token.Line, " at '"+token.Lexeme+"'", msg)
-	}
+// error records a syntax error and returns it, so that callers which cannot
+// continue parsing can unwind with panic.
+func (p *Parser) error(token Token, msg string) *ParseError {
+	err := &ParseError{Token: token, Line: token.Line, Message: msg}
+	p.report(err)
+	return err
 }
 
-func (p *Parser) report(line int, s string, msg string) {
-	log.Fatalf("%d %s : %s", line, s, msg)
+func (p *Parser) report(err *ParseError) {
+	p.errors = append(p.errors, err)
 }
 
-func (p *Parser) synchronize() {
-	p.advance()
-
+// synchronize discards tokens until the next statement boundary: after a
+// semicolon, after a closing brace, or before a keyword that starts a
+// statement. depth is how many braces the failed declaration opened and left
+// unclosed, which are skipped to their closing brace, so a malformed function
+// header does not leave its body to be parsed as stray statements. Inside a
+// block, a brace that closes the block is left for the block to consume.
+func (p *Parser) synchronize(depth int, inBlock bool) {
 	for !p.isAtEnd() {
-		if p.previous().TokenType == SEMICOLON {
+		if depth == 0 && inBlock && p.check(RightBrace) {
 			return
 		}
+		switch p.advance().TokenType {
+		case SEMICOLON:
+			if depth == 0 {
+				return
+			}
+		case LeftBrace:
+			depth++
+		case RightBrace:
+			if depth <= 1 {
+				return
+			}
+			depth--
+		}
+		if depth > 0 {
+			continue
+		}
 		switch p.peek().TokenType {
-		case CLASS:
-			return
-		case FUN:
-			return
-		case VAR:
-			return
-		case FOR:
-			return
-		case IF:
-			return
-		case WHILE:
-			return
-		case PRINT:
-			return
-		case RETURN:
+		case CLASS, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN, BREAK, CONTINUE, IMPORT, THROW, TRY:
 			return
 		}
-		p.advance()
 	}
 }
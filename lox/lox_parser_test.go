@@ -5,11 +5,45 @@ import (
 )
 
 func TestParser(t *testing.T) {
-	expr := "45.65"
+	expr := "45.65;"
 	lexer := NewScanner()
 	lexer.Eval(expr)
 	parser := NewParser(lexer.Tokens)
-	ast := parser.Parse()
+	ast, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
 	printer := NewAstPrinter()
 	printer.Print(ast)
 }
+
+func TestParser_Recovery(t *testing.T) {
+	prog := `var a = ;
+print a;
+fun f(x {
+  return x;
+}
+print "done";
+print ;`
+	lexer := NewScanner()
+	lexer.Eval(prog)
+	ast, err := NewParser(lexer.Tokens).Parse()
+
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expected three syntax errors, got %v", err)
+	}
+	for idx, line := range []int{1, 3, 7} {
+		if perr := errs[idx].(*ParseError); perr.Line != line {
+			t.Errorf("error %d: expected line %d, got %v", idx, line, perr)
+		}
+	}
+	if len(ast) != 2 {
+		t.Fatalf("expected the two valid statements, got %d", len(ast))
+	}
+	for _, stmt := range ast {
+		if _, ok := stmt.(*PrintStmt); !ok {
+			t.Fatalf("expected only the print statements to survive, got %T", stmt)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package lox
 
 import (
-	"log"
+	"sort"
+	"strings"
 )
 
 type FunctionType int
@@ -20,15 +21,40 @@ const (
 	SUBCLASS
 )
 
+// Scope tracks whether each name of a block is defined yet, and the slot it
+// occupies in the matching LoxEnvironment. Slots are numbered in declaration
+// order, which is the order the Interpreter defines them at runtime.
 type Scope struct {
-	s map[string]bool
+	s     map[string]bool
+	slots map[string]int
+	span  Span
+
+	// declared and used are only kept in lint mode.
+	declared []declaredName
+	used     map[string]bool
+
+	// symbols is only kept when the resolver feeds an Analysis.
+	symbols map[string]*Symbol
+}
+
+type declaredName struct {
+	name  Token
+	param bool
 }
 
 func NewScope() *Scope {
-	return &Scope{s: make(map[string]bool)}
+	return &Scope{
+		s:       make(map[string]bool),
+		slots:   make(map[string]int),
+		used:    make(map[string]bool),
+		symbols: make(map[string]*Symbol),
+	}
 }
 
 func (s *Scope) put(key string, value bool) {
+	if _, ok := s.slots[key]; !ok {
+		s.slots[key] = len(s.slots)
+	}
 	s.s[key] = value
 }
 
@@ -47,14 +73,53 @@ type LoxResolver struct {
 	scopes          []*Scope
 	currentFunction FunctionType
 	currentClass    ClassType
+	errors          ErrorList
+	// loops counts the loops enclosing the code being resolved, within the
+	// current function.
+	loops int
+
+	lint         bool
+	warnings     []*Warning
+	globals      map[string]bool
+	classes      map[string]*ClassStmt
+	superclass   string
+	initializing string
+
+	// analysis, when set, receives every declaration and resolved use.
+	analysis *Analysis
 }
 
+// NewResolver returns a resolver that records scope distances in i. With a
+// nil Interpreter it only performs the static checks.
 func NewResolver(i *Interpreter) *LoxResolver {
 	return &LoxResolver{i: i, currentFunction: NONE, currentClass: CNONE}
 }
 
+// NewLinter returns a resolver in lint mode. Besides the errors Resolve
+// returns, it collects warnings about code that is legal but likely wrong;
+// read them with Warnings once Resolve is done.
+func NewLinter() *LoxResolver {
+	l := NewResolver(nil)
+	l.lint = true
+	l.globals = make(map[string]bool)
+	l.classes = make(map[string]*ClassStmt)
+	return l
+}
+
+// Warnings returns the lint warnings found so far, in source order.
+func (l *LoxResolver) Warnings() []*Warning {
+	warnings := append([]*Warning(nil), l.warnings...)
+	sort.SliceStable(warnings, func(a, b int) bool {
+		if warnings[a].Line != warnings[b].Line {
+			return warnings[a].Line < warnings[b].Line
+		}
+		return warnings[a].Column < warnings[b].Column
+	})
+	return warnings
+}
+
 func (l *LoxResolver) VisitBlockStmt(b *BlockStmt) interface{} {
-	l.beginScope()
+	l.beginScope(b.Span())
 	l.resolveStatements(b.statements)
 	l.endScope()
 	return nil
@@ -62,8 +127,15 @@ func (l *LoxResolver) VisitBlockStmt(b *BlockStmt) interface{} {
 
 func (l *LoxResolver) VisitVariableStmt(s *VariableStmt) interface{} {
 	l.declare(s.name)
+	l.record(s.name, VariableSymbol, s, "var "+s.name.Lexeme)
 	if s.initializer != nil {
+		// Reading a local in its own initializer is an error, but a global
+		// just reads its previous value, or fails at runtime.
+		if l.lint && len(l.scopes) == 0 {
+			l.initializing = s.name.Lexeme
+		}
 		l.resolveExpr(s.initializer)
+		l.initializing = ""
 	}
 	l.define(s.name)
 	return nil
@@ -74,12 +146,17 @@ func (l *LoxResolver) VisitVariableExpr(e *VariableExpr) interface{} {
 	if len(l.scopes) > 0 {
 		n := len(l.scopes) - 1
 		scope := l.scopes[n]
-		varr, ok := scope.s[e.name.Lexeme]
-		if !varr || !ok {
+		defined, ok := scope.get(e.name.Lexeme)
+		if ok && !defined {
 			l.error(e.name, "Can't read local variable in its own initializer.")
 		}
+	} else if l.lint && e.name.Lexeme == l.initializing {
+		l.warn(e.name, "self-reference", "Variable '"+e.name.Lexeme+"' is used in its own initializer.")
 	}
 	l.resolveLocal(e, e.name)
+	if l.lint {
+		l.use(e.name)
+	}
 	return nil
 }
 
@@ -92,10 +169,23 @@ func (l *LoxResolver) VisitAssignExpr(b *AssignExpr) interface{} {
 func (l *LoxResolver) VisitFunctionStmt(f *FunctionStmt) interface{} {
 	l.declare(f.name)
 	l.define(f.name)
+	l.record(f.name, FunctionSymbol, f, "fun "+functionDetail(f))
 	l.resolveFunction(f, FUNCTION)
 	return nil
 }
 
+func (l *LoxResolver) VisitImportStmt(s *ImportStmt) interface{} {
+	if len(l.scopes) > 0 {
+		l.error(s.keyword, "Can only import at the top level.")
+	}
+	if s.alias != nil {
+		l.declare(*s.alias)
+		l.define(*s.alias)
+		l.record(*s.alias, VariableSymbol, s, "import "+s.path.Lexeme+" as "+s.alias.Lexeme)
+	}
+	return nil
+}
+
 func (l *LoxResolver) VisitExprStmt(e *ExprStmt) interface{} {
 	l.resolveExpr(e.expression)
 	return nil
@@ -132,7 +222,109 @@ func (l *LoxResolver) VisitReturnStmt(r *ReturnStmt) interface{} {
 
 func (l *LoxResolver) VisitWhileStmt(i *WhileStmt) interface{} {
 	l.resolveExpr(i.condition)
-	l.resolveStatement(i.body)
+	l.resolveLoopBody(i.body)
+	return nil
+}
+
+// resolveLoopBody resolves the body of a loop, where break and continue are
+// allowed.
+func (l *LoxResolver) resolveLoopBody(body Stmt) {
+	l.loops++
+	l.resolveStatement(body)
+	l.loops--
+}
+
+func (l *LoxResolver) VisitBreakStmt(b *BreakStmt) interface{} {
+	if l.loops == 0 {
+		l.error(b.keyword, "Can't use 'break' outside of a loop.")
+	}
+	return nil
+}
+
+func (l *LoxResolver) VisitContinueStmt(c *ContinueStmt) interface{} {
+	if l.loops == 0 {
+		l.error(c.keyword, "Can't use 'continue' outside of a loop.")
+	}
+	return nil
+}
+
+func (l *LoxResolver) VisitForStmt(f *ForStmt) interface{} {
+	l.beginScope(f.Span())
+	if f.initializer != nil {
+		l.resolveStatement(f.initializer)
+	}
+	if f.condition != nil {
+		l.resolveExpr(f.condition)
+	}
+	if f.increment != nil {
+		l.resolveExpr(f.increment)
+	}
+	l.resolveLoopBody(f.body)
+	l.endScope()
+	return nil
+}
+
+// VisitForInStmt gives the loop variable a scope of its own, which the
+// interpreter recreates for every element.
+func (l *LoxResolver) VisitForInStmt(f *ForInStmt) interface{} {
+	l.resolveExpr(f.iterable)
+	l.beginScope(f.Span())
+	l.declare(f.name)
+	l.define(f.name)
+	l.record(f.name, VariableSymbol, f, "var "+f.name.Lexeme)
+	l.resolveLoopBody(f.body)
+	l.endScope()
+	return nil
+}
+
+func (l *LoxResolver) VisitThrowStmt(t *ThrowStmt) interface{} {
+	l.resolveExpr(t.value)
+	return nil
+}
+
+// VisitTryStmt puts the error variable in one scope with the statements of
+// the catch clause, the way parameters share a scope with a function body.
+func (l *LoxResolver) VisitTryStmt(t *TryStmt) interface{} {
+	l.resolveStatement(t.body)
+	if t.catchBody != nil {
+		l.beginScope(t.name.Span().Join(t.catchBody.Span()))
+		l.declare(t.name)
+		l.define(t.name)
+		l.record(t.name, VariableSymbol, t, "catch ("+t.name.Lexeme+")")
+		l.resolveStatements(t.catchBody.statements)
+		l.endScope()
+	}
+	if t.finallyBody != nil {
+		l.resolveStatement(t.finallyBody)
+	}
+	return nil
+}
+
+func (l *LoxResolver) VisitListExpr(e *ListExpr) interface{} {
+	for _, element := range e.elements {
+		l.resolveExpr(element)
+	}
+	return nil
+}
+
+func (l *LoxResolver) VisitMapExpr(e *MapExpr) interface{} {
+	for idx, key := range e.keys {
+		l.resolveExpr(key)
+		l.resolveExpr(e.values[idx])
+	}
+	return nil
+}
+
+func (l *LoxResolver) VisitIndexExpr(e *IndexExpr) interface{} {
+	l.resolveExpr(e.object)
+	l.resolveExpr(e.index)
+	return nil
+}
+
+func (l *LoxResolver) VisitSetIndexExpr(e *SetIndexExpr) interface{} {
+	l.resolveExpr(e.value)
+	l.resolveExpr(e.object)
+	l.resolveExpr(e.index)
 	return nil
 }
 
@@ -171,7 +363,19 @@ func (l *LoxResolver) VisitUnaryExpr(e *UnaryExpr) interface{} {
 }
 
 func (l *LoxResolver) resolveStatements(statements []Stmt) {
-	for _, stmt := range statements {
+	for idx, stmt := range statements {
+		if l.lint && idx < len(statements)-1 {
+			switch jump := stmt.(type) {
+			case *ReturnStmt:
+				l.warn(jump.keyword, "unreachable", "Unreachable code after 'return'.")
+			case *BreakStmt:
+				l.warn(jump.keyword, "unreachable", "Unreachable code after 'break'.")
+			case *ContinueStmt:
+				l.warn(jump.keyword, "unreachable", "Unreachable code after 'continue'.")
+			case *ThrowStmt:
+				l.warn(jump.keyword, "unreachable", "Unreachable code after 'throw'.")
+			}
+		}
 		l.resolveStatement(stmt)
 	}
 }
@@ -184,20 +388,35 @@ func (l *LoxResolver) resolveExpr(expr Expr) {
 	expr.Accept(l)
 }
 
-func (l *LoxResolver) beginScope() {
-	l.scopes = append(l.scopes, NewScope())
+// beginScope opens the scope of the node covering span.
+func (l *LoxResolver) beginScope(span Span) {
+	scope := NewScope()
+	scope.span = span
+	l.scopes = append(l.scopes, scope)
 }
 
 func (l *LoxResolver) endScope() {
 	n := len(l.scopes) - 1
+	if l.lint {
+		l.reportUnused(l.scopes[n])
+	}
+	if l.analysis != nil {
+		l.analysis.closeScope(l.scopes[n])
+	}
 	l.scopes[n] = nil
 	l.scopes = l.scopes[:n]
 }
 
 func (l *LoxResolver) declare(name Token) {
 	if len(l.scopes) == 0 {
+		if l.lint {
+			l.globals[name.Lexeme] = true
+		}
 		return
 	}
+	if l.lint {
+		l.checkShadowing(name)
+	}
 
 	//TOP
 	n := len(l.scopes) - 1
@@ -221,32 +440,56 @@ func (l *LoxResolver) define(name Token) {
 
 func (l *LoxResolver) resolveLocal(e Expr, name Token) {
 	for i := len(l.scopes) - 1; i >= 0; i-- {
-		_, ok := l.scopes[i].s[name.Lexeme]
+		slot, ok := l.scopes[i].slots[name.Lexeme]
 		if ok {
-			l.i.resolve(e, len(l.scopes)-1-i)
+			if l.i != nil {
+				l.i.resolve(e, len(l.scopes)-1-i, slot)
+			}
+			if l.analysis != nil {
+				l.analysis.use(name, l.scopes[i])
+			}
+			return
 		}
 	}
+	if l.analysis != nil {
+		l.analysis.use(name, nil)
+	}
 }
 
 func (l *LoxResolver) error(name Token, msg string) {
-	log.Fatal(name, msg)
+	l.errors = append(l.errors, &ResolveError{Token: name, Line: name.Line, Message: msg})
+}
+
+func (l *LoxResolver) VisitFunctionExpr(f *FunctionExpr) interface{} {
+	l.resolveFunction(f.declaration, FUNCTION)
+	return nil
 }
 
 func (l *LoxResolver) resolveFunction(f *FunctionStmt, ftype FunctionType) {
 	enclosingType := l.currentFunction
 	l.currentFunction = ftype
-	l.beginScope()
+	// A loop around the declaration does not extend into the body.
+	enclosingLoops := l.loops
+	l.loops = 0
+	l.beginScope(f.Span())
 	for _, param := range f.params {
 		l.declare(param)
 		l.define(param)
+		l.record(param, ParameterSymbol, f, "parameter "+param.Lexeme)
+		if l.lint {
+			scope := l.scopes[len(l.scopes)-1]
+			scope.declared[len(scope.declared)-1].param = true
+		}
 	}
 	l.resolveStatements(f.body)
 	l.endScope()
 	l.currentFunction = enclosingType
+	l.loops = enclosingLoops
 }
 
-func (l *LoxResolver) Resolve(statements []Stmt) {
+func (l *LoxResolver) Resolve(statements []Stmt) error {
 	l.resolveStatements(statements)
+	return l.errors.Err()
 }
 
 func (l *LoxResolver) VisitClassStmt(c *ClassStmt) interface{} {
@@ -255,6 +498,21 @@ func (l *LoxResolver) VisitClassStmt(c *ClassStmt) interface{} {
 	l.declare(c.name)
 	l.define(c.name)
 
+	detail := "class " + c.name.Lexeme
+	if c.superclass != nil {
+		detail += " < " + c.superclass.name.Lexeme
+	}
+	class := l.record(c.name, ClassSymbol, c, detail)
+
+	enclosingSuperclass := l.superclass
+	l.superclass = ""
+	if l.lint {
+		l.classes[c.name.Lexeme] = c
+		if c.superclass != nil {
+			l.superclass = c.superclass.name.Lexeme
+		}
+	}
+
 	if c.superclass != nil && c.name.Lexeme == c.superclass.name.Lexeme {
 		l.error(c.superclass.name,
 			"A class can't inherit from itself.")
@@ -262,19 +520,25 @@ func (l *LoxResolver) VisitClassStmt(c *ClassStmt) interface{} {
 
 	if c.superclass != nil {
 		l.currentClass = SUBCLASS
-		l.beginScope()
+		l.resolveExpr(c.superclass)
+		l.beginScope(c.Span())
 		n := len(l.scopes) - 1
 		scope := l.scopes[n]
 		scope.put("super", true)
-		l.resolveExpr(c.superclass)
 	}
 
-	l.beginScope()
+	l.beginScope(c.Span())
 	n := len(l.scopes) - 1
 	scope := l.scopes[n]
 	scope.put("this", true)
 
 	for _, method := range c.methods {
+		if l.analysis != nil {
+			fn := method.(*FunctionStmt)
+			sym := l.analysis.declare(fn.name, MethodSymbol, fn, "method "+c.name.Lexeme+"."+functionDetail(fn), nil)
+			sym.Parent = class
+			class.Children = append(class.Children, sym)
+		}
 		declaration := METHOD
 		if method.(*FunctionStmt).name.Lexeme == "init" {
 			declaration = INITIALIZER
@@ -286,6 +550,7 @@ func (l *LoxResolver) VisitClassStmt(c *ClassStmt) interface{} {
 	if c.superclass != nil {
 		l.endScope()
 	}
+	l.superclass = enclosingSuperclass
 	l.currentClass = enclosingClass
 	return nil
 }
@@ -317,6 +582,92 @@ func (l *LoxResolver) VisitSuperExpr(e *SuperExpr) interface{} {
 	if l.currentClass != SUBCLASS {
 		l.error(e.keyword, "Can't use 'super' in a class with no superclass.")
 	}
+	if l.lint && l.superclass != "" {
+		l.checkSuperMethod(e.method)
+	}
 	l.resolveLocal(e, e.keyword)
 	return nil
 }
+
+func (l *LoxResolver) warn(name Token, check string, msg string) {
+	l.warnings = append(l.warnings, &Warning{Token: name, Line: name.Line, Column: name.Column, Check: check, Message: msg})
+}
+
+// use marks the innermost local called name as read.
+func (l *LoxResolver) use(name Token) {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if l.scopes[i].containsKey(name.Lexeme) {
+			l.scopes[i].used[name.Lexeme] = true
+			return
+		}
+	}
+}
+
+// reportUnused warns about the names of scope that were never read. Names
+// starting with an underscore are meant to be ignored.
+func (l *LoxResolver) reportUnused(scope *Scope) {
+	for _, decl := range scope.declared {
+		name := decl.name.Lexeme
+		if scope.used[name] || strings.HasPrefix(name, "_") {
+			continue
+		}
+		if decl.param {
+			l.warn(decl.name, "unused", "Parameter '"+name+"' is never used.")
+		} else {
+			l.warn(decl.name, "unused", "Local variable '"+name+"' is never used.")
+		}
+	}
+}
+
+func (l *LoxResolver) checkShadowing(name Token) {
+	scope := l.scopes[len(l.scopes)-1]
+	if !scope.containsKey(name.Lexeme) {
+		scope.declared = append(scope.declared, declaredName{name: name})
+	}
+	for i := len(l.scopes) - 2; i >= 0; i-- {
+		if l.scopes[i].containsKey(name.Lexeme) {
+			l.warn(name, "shadow", "Variable '"+name.Lexeme+"' shadows a variable in an enclosing scope.")
+			return
+		}
+	}
+	if l.globals[name.Lexeme] {
+		l.warn(name, "shadow", "Variable '"+name.Lexeme+"' shadows a global variable.")
+	}
+}
+
+// checkSuperMethod warns when a super call names a method that no class in
+// the superclass chain declares. It gives up on classes it has not seen.
+func (l *LoxResolver) checkSuperMethod(method Token) {
+	seen := make(map[string]bool)
+	name := l.superclass
+	for name != "" {
+		class, ok := l.classes[name]
+		if !ok || seen[name] {
+			return
+		}
+		seen[name] = true
+		for _, m := range class.methods {
+			if m.(*FunctionStmt).name.Lexeme == method.Lexeme {
+				return
+			}
+		}
+		name = ""
+		if class.superclass != nil {
+			name = class.superclass.name.Lexeme
+		}
+	}
+	l.warn(method, "super", "Superclass '"+l.superclass+"' has no method '"+method.Lexeme+"'.")
+}
+
+// record passes a declaration in the current scope on to the analysis, if
+// there is one.
+func (l *LoxResolver) record(name Token, kind SymbolKind, decl Stmt, detail string) *Symbol {
+	if l.analysis == nil {
+		return nil
+	}
+	var scope *Scope
+	if len(l.scopes) > 0 {
+		scope = l.scopes[len(l.scopes)-1]
+	}
+	return l.analysis.declare(name, kind, decl, detail, scope)
+}
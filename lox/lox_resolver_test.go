@@ -0,0 +1,96 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestLinter(t *testing.T) {
+	prog := `var count = count;
+fun greet(name, _unused) {
+  var greeting = "hi";
+  return name;
+  print "after";
+}
+{
+  var count = 1;
+  print count;
+}
+class A {
+  hello() {}
+}
+class B < A {
+  hello() {
+    super.goodbye();
+  }
+}`
+	linter := NewLinter()
+	if err := linter.Resolve(parse(t, prog)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"[line 1:13] Warning at 'count': Variable 'count' is used in its own initializer.",
+		"[line 3:7] Warning at 'greeting': Local variable 'greeting' is never used.",
+		"[line 4:3] Warning at 'return': Unreachable code after 'return'.",
+		"[line 8:7] Warning at 'count': Variable 'count' shadows a global variable.",
+		"[line 16:11] Warning at 'goodbye': Superclass 'A' has no method 'goodbye'.",
+	}
+	warnings := linter.Warnings()
+	if len(warnings) != len(want) {
+		for _, w := range warnings {
+			t.Log(w)
+		}
+		t.Fatalf("expected %d warnings, got %d", len(want), len(warnings))
+	}
+	for idx, w := range warnings {
+		if w.String() != want[idx] {
+			t.Errorf("expected %q, got %q", want[idx], w.String())
+		}
+	}
+}
+
+func TestLinter_UnusedParameter(t *testing.T) {
+	linter := NewLinter()
+	if err := linter.Resolve(parse(t, "fun f(a, b) { return a; }")); err != nil {
+		t.Fatal(err)
+	}
+	warnings := linter.Warnings()
+	if len(warnings) != 1 || warnings[0].Check != "unused" || warnings[0].Message != "Parameter 'b' is never used." {
+		t.Fatalf("expected b to be reported unused, got %v", warnings)
+	}
+}
+
+func TestResolver_NoWarningsByDefault(t *testing.T) {
+	resolver := NewResolver(nil)
+	if err := resolver.Resolve(parse(t, "fun f(a) { var b; return; print 1; }")); err != nil {
+		t.Fatal(err)
+	}
+	if len(resolver.Warnings()) != 0 {
+		t.Fatal("expected no warnings outside lint mode")
+	}
+}
+
+func TestLinter_UnreachableAfterLoopJumps(t *testing.T) {
+	linter := NewLinter()
+	prog := "while (true) {\n  break;\n  print 1;\n}\nfor (;;) {\n  continue;\n  print 2;\n}"
+	if err := linter.Resolve(parse(t, prog)); err != nil {
+		t.Fatal(err)
+	}
+	warnings := linter.Warnings()
+	if len(warnings) != 2 || warnings[0].Line != 2 || warnings[1].Line != 6 ||
+		warnings[1].Message != "Unreachable code after 'continue'." {
+		t.Fatalf("expected unreachable code after break and continue, got %v", warnings)
+	}
+}
+
+func TestLinter_UnreachableAfterThrow(t *testing.T) {
+	linter := NewLinter()
+	prog := "fun f() {\n  throw \"no\";\n  print 1;\n}"
+	if err := linter.Resolve(parse(t, prog)); err != nil {
+		t.Fatal(err)
+	}
+	warnings := linter.Warnings()
+	if len(warnings) != 1 || warnings[0].Line != 2 || warnings[0].Message != "Unreachable code after 'throw'." {
+		t.Fatalf("expected unreachable code after throw, got %v", warnings)
+	}
+}
@@ -0,0 +1,120 @@
+package lox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Limits bounds the work a program may do, for running scripts that are not
+// trusted. A zero field leaves that resource unbounded. The counts start
+// over with every call of Interpret.
+type Limits struct {
+	// MaxSteps bounds the loop iterations and calls a program makes.
+	MaxSteps int
+	// MaxCallDepth bounds how many calls may be active at once, like
+	// SetMaxCallDepth; zero keeps the backend's default. The VM also stops
+	// with a stack overflow once its value stack holds stackMax values.
+	MaxCallDepth int
+	// MaxValues bounds how many strings, lists, maps, functions, classes
+	// and instances a program creates, counting what natives return.
+	MaxValues int
+	// MaxStringBytes bounds the total length of the strings it creates.
+	MaxStringBytes int
+}
+
+// Capability is a set of natives and statements that reach outside the
+// program. A backend starts out with AllCapabilities; SetCapabilities
+// withholds the rest, so untrusted scripts cannot, say, read the clock or
+// load files.
+type Capability int
+
+const (
+	// ClockCapability grants the clock native.
+	ClockCapability Capability = 1 << iota
+	// InputCapability grants the input native, which reads the backend's input.
+	InputCapability
+	// ImportCapability grants import statements, which read module files.
+	ImportCapability
+
+	AllCapabilities = ClockCapability | InputCapability | ImportCapability
+)
+
+// LimitError reports a program stopped for exceeding one of the Limits of
+// its backend, or because the context it ran under was done. Unlike a
+// RuntimeError, it cannot be caught and runs no finally clauses. Err is the
+// context's error in the latter case.
+type LimitError struct {
+	Line    int
+	Message string
+	Stack   []string
+	Err     error
+}
+
+func (e *LimitError) Error() string {
+	if len(e.Stack) == 0 {
+		return fmt.Sprintf("%s\n[line %d]", e.Message, e.Line)
+	}
+	return e.Message + "\n" + strings.Join(e.Stack, "\n")
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// budget tracks how much of its Limits a program has used and watches the
+// context it runs under. The errors it returns lack the line and stack
+// trace, which the backend fills in.
+type budget struct {
+	limits      Limits
+	ctx         context.Context
+	done        <-chan struct{}
+	steps       int
+	values      int
+	stringBytes int
+}
+
+// start resets the counts for a program running under ctx.
+func (b *budget) start(ctx context.Context) {
+	b.ctx = ctx
+	b.done = ctx.Done()
+	b.steps = 0
+	b.values = 0
+	b.stringBytes = 0
+}
+
+// step counts a loop iteration or call.
+func (b *budget) step() *LimitError {
+	select {
+	case <-b.done:
+		if b.ctx.Err() == context.DeadlineExceeded {
+			return &LimitError{Message: "Time limit exceeded.", Err: b.ctx.Err()}
+		}
+		return &LimitError{Message: "Execution cancelled.", Err: b.ctx.Err()}
+	default:
+	}
+	b.steps++
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return &LimitError{Message: "Step limit exceeded."}
+	}
+	return nil
+}
+
+// allocate counts value if it is one a program allocates rather than a
+// number, boolean or nil.
+func (b *budget) allocate(value Value) *LimitError {
+	switch v := value.(type) {
+	case nil, bool, float64:
+		return nil
+	case string:
+		b.stringBytes += len(v)
+		if b.limits.MaxStringBytes > 0 && b.stringBytes > b.limits.MaxStringBytes {
+			return &LimitError{Message: "String limit exceeded."}
+		}
+	}
+	b.values++
+	if b.limits.MaxValues > 0 && b.values > b.limits.MaxValues {
+		return &LimitError{Message: "Value limit exceeded."}
+	}
+	return nil
+}
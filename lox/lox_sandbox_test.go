@@ -0,0 +1,132 @@
+package lox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSandbox_Limits(t *testing.T) {
+	tests := []struct {
+		prog    string
+		limits  Limits
+		message string
+	}{
+		{"while (true) {}", Limits{MaxSteps: 1000}, "Step limit exceeded."},
+		{"for (;;) {}", Limits{MaxSteps: 1000}, "Step limit exceeded."},
+		{"fun f() {}\nwhile (true) f();", Limits{MaxSteps: 1000}, "Step limit exceeded."},
+		{"var l;\nwhile (true) l = [1];", Limits{MaxValues: 100}, "Value limit exceeded."},
+		{"class A {}\nwhile (true) A();", Limits{MaxValues: 100}, "Value limit exceeded."},
+		{`var s = "ab";` + "\nwhile (true) s = s + s;", Limits{MaxStringBytes: 1 << 20}, "String limit exceeded."},
+		{"fun f() { f(); }\nf();", Limits{MaxCallDepth: 10}, "Stack overflow."},
+	}
+	for name, kind := range backends {
+		for _, test := range tests {
+			backend := newTestBackend(kind)
+			backend.SetLimits(test.limits)
+			err := backend.Interpret(parse(t, test.prog))
+			var message string
+			switch e := err.(type) {
+			case *LimitError:
+				message = e.Message
+			case *RuntimeError:
+				message = e.Message
+			}
+			if message != test.message {
+				t.Errorf("%s: %q: expected %q, got %v", name, test.prog, test.message, err)
+			}
+		}
+	}
+}
+
+func TestSandbox_StackExhaustion(t *testing.T) {
+	// Every frame holds a 2000-element literal while it recurses, so the VM
+	// runs out of stack long before the call depth is reached.
+	elements := strings.Repeat("1, ", 2000)
+	prog := "fun f() { return [" + elements + "f()]; }\nf();"
+	for name, kind := range backends {
+		backend := newTestBackend(kind)
+		backend.SetLimits(Limits{MaxSteps: 1000000, MaxValues: 1000000, MaxCallDepth: 2000})
+		err := backend.Interpret(parse(t, prog))
+		if rerr, ok := err.(*RuntimeError); !ok || rerr.Message != "Stack overflow." {
+			t.Fatalf("%s: expected a stack overflow, got %v", name, err)
+		}
+		if err := backend.Interpret(parse(t, "var l = ["+strings.Repeat("1, ", 20000)+"1];")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSandbox_LimitsCannotBeCaught(t *testing.T) {
+	prog := `var caught = false;
+	try {
+		while (true) {}
+	} catch (e) {
+		caught = true;
+	} finally {
+		print "finally";
+	}`
+	for name, kind := range backends {
+		var out bytes.Buffer
+		backend := NewBackendWithOutput(kind, &out, ioutil.Discard)
+		backend.SetLimits(Limits{MaxSteps: 100})
+		err := backend.Interpret(parse(t, prog))
+		if _, ok := err.(*LimitError); !ok {
+			t.Fatalf("%s: expected *LimitError, got %v", name, err)
+		}
+		if caught, _ := backend.Get("caught"); caught != false || out.Len() != 0 {
+			t.Errorf("%s: the limit error was handled by the script", name)
+		}
+		// The counts start over, so the backend still runs small programs.
+		if err := backend.Interpret(parse(t, "for (var i = 0; i < 50; i = i + 1) {}")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSandbox_Context(t *testing.T) {
+	for name, kind := range backends {
+		backend := newTestBackend(kind)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := backend.InterpretContext(ctx, parse(t, "fun f() {}\nwhile (true) f();"))
+		cancel()
+		lerr, ok := err.(*LimitError)
+		if !ok || lerr.Message != "Time limit exceeded." || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: expected a time limit error, got %v", name, err)
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		err = backend.InterpretContext(ctx, parse(t, "while (true) {}"))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s: expected a cancelled program, got %v", name, err)
+		}
+	}
+}
+
+func TestSandbox_Capabilities(t *testing.T) {
+	for name, kind := range backends {
+		backend := newTestBackend(kind)
+		backend.SetCapabilities(AllCapabilities &^ (ClockCapability | ImportCapability))
+		err := backend.Interpret(parse(t, "clock();"))
+		if rerr, ok := err.(*RuntimeError); !ok || rerr.Message != "Undefined variable 'clock'." {
+			t.Errorf("%s: expected clock to be withheld, got %v", name, err)
+		}
+		err = backend.Interpret(parse(t, `import "testdata/modules/shapes.lox";`))
+		if rerr, ok := err.(*RuntimeError); !ok || rerr.Message != "Imports are not allowed." {
+			t.Errorf("%s: expected imports to be withheld, got %v", name, err)
+		}
+		if _, ok := backend.Get("input"); !ok {
+			t.Errorf("%s: expected input to be granted", name)
+		}
+
+		backend.SetCapabilities(AllCapabilities)
+		if err := backend.Interpret(parse(t, "var t = clock();")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
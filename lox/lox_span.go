@@ -0,0 +1,109 @@
+package lox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Position is a point in Lox source. Line and Column are 1-based; Column and
+// Offset count bytes.
+type Position struct {
+	Line   int
+	Column int
+	Offset int
+}
+
+// Span is the source range of a token or AST node, from Start up to but not
+// including End.
+type Span struct {
+	File  string
+	Start Position
+	End   Position
+}
+
+func (s Span) String() string {
+	if s.File == "" {
+		return fmt.Sprintf("%d:%d", s.Start.Line, s.Start.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Start.Line, s.Start.Column)
+}
+
+// Join returns the span from the start of s to the end of other.
+func (s Span) Join(other Span) Span {
+	return Span{File: s.File, Start: s.Start, End: other.End}
+}
+
+// Span returns the source range of the token's lexeme.
+func (t Token) Span() Span {
+	start := Position{Line: t.Line, Column: t.Column, Offset: t.Offset}
+	end := Position{Line: t.Line, Column: t.Column + len(t.Lexeme), Offset: t.Offset + len(t.Lexeme)}
+	if n := strings.Count(t.Lexeme, "\n"); n > 0 {
+		end.Line += n
+		end.Column = len(t.Lexeme) - strings.LastIndex(t.Lexeme, "\n")
+	}
+	return Span{File: t.File, Start: start, End: end}
+}
+
+// node is embedded in every Expr and Stmt to record the source it was
+// parsed from.
+type node struct {
+	span Span
+}
+
+func (n *node) Span() Span {
+	return n.span
+}
+
+func (n *node) setSpan(span Span) {
+	n.span = span
+}
+
+// setSpan records span on an Expr or Stmt.
+func setSpan(n interface{}, span Span) {
+	n.(interface{ setSpan(Span) }).setSpan(span)
+}
+
+// Snippet renders the source line where span starts with the span
+// underlined by carets, for example:
+//
+//	3 | print a + ;
+//	  |           ^
+//
+// A span running past the end of its first line is underlined to the end of
+// that line.
+func Snippet(source string, span Span) string {
+	lines := strings.Split(source, "\n")
+	if span.Start.Line < 1 || span.Start.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[span.Start.Line-1], "\r")
+	column := span.Start.Column
+	if column < 1 {
+		column = 1
+	}
+	if column > len(line)+1 {
+		column = len(line) + 1
+	}
+
+	width := len(line) + 1 - column
+	if span.End.Line == span.Start.Line {
+		width = span.End.Column - column
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	// Keep tabs in the indentation so the carets line up with the source.
+	var indent strings.Builder
+	for _, c := range line[:column-1] {
+		if c == '\t' {
+			indent.WriteByte('\t')
+		} else {
+			indent.WriteByte(' ')
+		}
+	}
+
+	gutter := fmt.Sprintf("%3d | ", span.Start.Line)
+	pad := strings.Repeat(" ", len(gutter)-2) + "| "
+	return gutter + line + "\n" + pad + indent.String() + strings.Repeat("^", width)
+}
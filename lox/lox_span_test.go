@@ -0,0 +1,58 @@
+package lox
+
+import (
+	"testing"
+)
+
+func TestSpan_Nodes(t *testing.T) {
+	source := "var x = 1;\nprint x + (2 * 3);\nfor (var i = 0; i < 2; i = i + 1) {\n  print \"a\nb\";\n}"
+	lexer := NewScanner()
+	lexer.File = "spans.lox"
+	if err := lexer.Eval(source); err != nil {
+		t.Fatal(err)
+	}
+	ast, err := NewParser(lexer.Tokens).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := func(n interface{ Span() Span }) string {
+		span := n.Span()
+		return source[span.Start.Offset:span.End.Offset]
+	}
+
+	if got := text(ast[0]); got != "var x = 1;" {
+		t.Errorf("unexpected var span %q", got)
+	}
+	print := ast[1].(*PrintStmt)
+	if got := text(print.expression); got != "x + (2 * 3)" {
+		t.Errorf("unexpected binary span %q", got)
+	}
+	group := print.expression.(*BinaryExpr).right
+	if got := text(group); got != "(2 * 3)" {
+		t.Errorf("unexpected group span %q", got)
+	}
+	if span := group.Span(); span.String() != "spans.lox:2:11" || span.End.Column != 18 {
+		t.Errorf("unexpected group position %v to %+v", span, span.End)
+	}
+	loop := ast[2]
+	if got := text(loop); got != source[30:] {
+		t.Errorf("unexpected for span %q", got)
+	}
+	if end := loop.Span().End; end.Line != 6 || end.Column != 2 {
+		t.Errorf("expected the for loop to end at 6:2, got %+v", end)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	source := "var a = 1;\n\tprint a + ;"
+	lexer := NewScanner()
+	lexer.Eval(source)
+	_, err := NewParser(lexer.Tokens).Parse()
+	perr := err.(ErrorList)[0].(*ParseError)
+
+	want := "  2 | \tprint a + ;\n    | \t          ^"
+	if got := Snippet(source, perr.Token.Span()); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
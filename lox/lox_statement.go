@@ -2,51 +2,123 @@ package lox
 
 type Stmt interface {
 	Accept(p Visitor) interface{}
+	Span() Span
 }
 
 type DeclarationStmt struct {
 }
 
 type FunctionStmt struct {
+	node
 	name   Token
 	params []Token
 	body   []Stmt
 }
 
 type IfStmt struct {
+	node
 	condition  Expr
 	thenBranch Stmt
 	elseBranch Stmt
 }
 
 type PrintStmt struct {
+	node
 	expression Expr
 }
 
 type ReturnStmt struct {
+	node
 	keyword Token
 	value   Expr
 }
 
 type WhileStmt struct {
+	node
 	condition Expr
 	body      Stmt
 }
 
+// ForStmt is a for loop. Any of its clauses may be nil.
+type ForStmt struct {
+	node
+	initializer Stmt
+	condition   Expr
+	increment   Expr
+	body        Stmt
+}
+
+// ForInStmt runs body once for each element of a list, or each key of a
+// map, with the element bound to name: for (var name in iterable) body.
+type ForInStmt struct {
+	node
+	name     Token
+	iterable Expr
+	body     Stmt
+}
+
+// ImportStmt runs a module and binds what it exports: import "path"; defines
+// each export as a global, import "path" as name; binds the module to name.
+// alias is nil without "as".
+type ImportStmt struct {
+	node
+	keyword Token
+	path    Token
+	alias   *Token
+}
+
+// ThrowStmt raises value as an exception, for the innermost enclosing try
+// statement with a catch clause to handle.
+type ThrowStmt struct {
+	node
+	keyword Token
+	value   Expr
+}
+
+// TryStmt runs body, handing an exception raised in it to catchBody with
+// the thrown value bound to name, and then runs finallyBody however the
+// statement is left. catchBody or finallyBody is nil when the clause is
+// missing.
+type TryStmt struct {
+	node
+	keyword     Token
+	body        *BlockStmt
+	name        Token
+	catchBody   *BlockStmt
+	finallyBody *BlockStmt
+}
+
+// BreakStmt leaves the innermost loop.
+type BreakStmt struct {
+	node
+	keyword Token
+}
+
+// ContinueStmt skips to the next iteration of the innermost loop, running
+// the increment clause of a for loop first.
+type ContinueStmt struct {
+	node
+	keyword Token
+}
+
 type ExprStmt struct {
+	node
 	expression Expr
 }
 
 type VariableStmt struct {
+	node
 	name        Token
 	initializer Expr
 }
 
 type BlockStmt struct {
+	node
 	statements []Stmt
 }
 
 type ClassStmt struct {
+	node
 	name       Token
 	superclass *VariableExpr
 	methods    []Stmt
@@ -67,6 +139,53 @@ func NewWhileStmt(condition Expr, statement Stmt) Stmt {
 	}
 }
 
+func NewForStmt(initializer Stmt, condition Expr, increment Expr, body Stmt) Stmt {
+	return &ForStmt{
+		initializer: initializer,
+		condition:   condition,
+		increment:   increment,
+		body:        body,
+	}
+}
+
+func NewForInStmt(name Token, iterable Expr, body Stmt) Stmt {
+	return &ForInStmt{
+		name:     name,
+		iterable: iterable,
+		body:     body,
+	}
+}
+
+func NewImportStmt(keyword Token, path Token, alias *Token) Stmt {
+	return &ImportStmt{
+		keyword: keyword,
+		path:    path,
+		alias:   alias,
+	}
+}
+
+func NewThrowStmt(keyword Token, value Expr) Stmt {
+	return &ThrowStmt{keyword: keyword, value: value}
+}
+
+func NewTryStmt(keyword Token, body *BlockStmt, name Token, catchBody *BlockStmt, finallyBody *BlockStmt) Stmt {
+	return &TryStmt{
+		keyword:     keyword,
+		body:        body,
+		name:        name,
+		catchBody:   catchBody,
+		finallyBody: finallyBody,
+	}
+}
+
+func NewBreakStmt(keyword Token) Stmt {
+	return &BreakStmt{keyword: keyword}
+}
+
+func NewContinueStmt(keyword Token) Stmt {
+	return &ContinueStmt{keyword: keyword}
+}
+
 func NewBlockStmt(statements []Stmt) Stmt {
 	return &BlockStmt{statements: statements}
 }
@@ -99,26 +218,24 @@ func NewReturnStmt(keyword Token, value Expr) Stmt {
 }
 
 func NewClassStmt(name Token, superclass Expr, methods []Stmt) Stmt {
+	super, _ := superclass.(*VariableExpr)
 	return &ClassStmt{
 		name:       name,
-		superclass: superclass.(*VariableExpr),
+		superclass: super,
 		methods:    methods,
 	}
 }
 
 func (p *PrintStmt) Accept(v Visitor) interface{} {
-	v.VisitPrintStmt(p)
-	return nil
+	return v.VisitPrintStmt(p)
 }
 
 func (s *VariableStmt) Accept(v Visitor) interface{} {
-	v.VisitVariableStmt(s)
-	return nil
+	return v.VisitVariableStmt(s)
 }
 
 func (e *ExprStmt) Accept(v Visitor) interface{} {
-	v.VisitExprStmt(e)
-	return nil
+	return v.VisitExprStmt(e)
 }
 
 func (b *BlockStmt) Accept(v Visitor) interface{} {
@@ -133,6 +250,22 @@ func (w *WhileStmt) Accept(v Visitor) interface{} {
 	return v.VisitWhileStmt(w)
 }
 
+func (f *ForStmt) Accept(v Visitor) interface{} {
+	return v.VisitForStmt(f)
+}
+
+func (f *ForInStmt) Accept(v Visitor) interface{} {
+	return v.VisitForInStmt(f)
+}
+
+func (b *BreakStmt) Accept(v Visitor) interface{} {
+	return v.VisitBreakStmt(b)
+}
+
+func (c *ContinueStmt) Accept(v Visitor) interface{} {
+	return v.VisitContinueStmt(c)
+}
+
 func (f *FunctionStmt) Accept(v Visitor) interface{} {
 	return v.VisitFunctionStmt(f)
 }
@@ -144,3 +277,15 @@ func (r *ReturnStmt) Accept(v Visitor) interface{} {
 func (c *ClassStmt) Accept(v Visitor) interface{} {
 	return v.VisitClassStmt(c)
 }
+
+func (i *ImportStmt) Accept(v Visitor) interface{} {
+	return v.VisitImportStmt(i)
+}
+
+func (t *ThrowStmt) Accept(v Visitor) interface{} {
+	return v.VisitThrowStmt(t)
+}
+
+func (t *TryStmt) Accept(v Visitor) interface{} {
+	return v.VisitTryStmt(t)
+}
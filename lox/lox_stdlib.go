@@ -0,0 +1,294 @@
+package lox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stdlib lists the natives every backend defines at creation, apart from
+// those of capabilityNatives.
+var stdlib = []struct {
+	name  string
+	arity int
+	fn    NativeFunc
+}{
+	{"type", 1, typeOf},
+	{"str", 1, str},
+	{"num", 1, num},
+
+	{"abs", 1, mathFunc("abs", math.Abs)},
+	{"floor", 1, mathFunc("floor", math.Floor)},
+	{"ceil", 1, mathFunc("ceil", math.Ceil)},
+	{"round", 1, mathFunc("round", math.Round)},
+	{"sqrt", 1, mathFunc("sqrt", math.Sqrt)},
+	{"sin", 1, mathFunc("sin", math.Sin)},
+	{"cos", 1, mathFunc("cos", math.Cos)},
+	{"tan", 1, mathFunc("tan", math.Tan)},
+	{"log", 1, mathFunc("log", math.Log)},
+	{"exp", 1, mathFunc("exp", math.Exp)},
+	{"pow", 2, mathFunc2("pow", math.Pow)},
+	{"min", 2, mathFunc2("min", math.Min)},
+	{"max", 2, mathFunc2("max", math.Max)},
+
+	{"len", 1, length},
+	{"substr", 3, substr},
+	{"split", 2, split},
+	{"upper", 1, stringFunc("upper", strings.ToUpper)},
+	{"lower", 1, stringFunc("lower", strings.ToLower)},
+	{"trim", 1, stringFunc("trim", strings.TrimSpace)},
+	{"format", 2, format},
+
+	{"Error", 1, newError},
+}
+
+// capabilityNative is a native a backend defines only while it holds
+// capability.
+type capabilityNative struct {
+	capability Capability
+	name       string
+	arity      int
+	fn         NativeFunc
+}
+
+// capabilityNatives lists the natives that reach outside the program. The
+// input native reads lines from input.
+func capabilityNatives(input *lineInput) []capabilityNative {
+	return []capabilityNative{
+		{ClockCapability, "clock", 0, clock},
+		{InputCapability, "input", 0, input.readLine},
+	}
+}
+
+// defineStdlib registers the standard library with define, including every
+// capability native.
+func defineStdlib(define func(name string, arity int, fn NativeFunc), input *lineInput) {
+	for _, native := range stdlib {
+		define(native.name, native.arity, native.fn)
+	}
+	for _, native := range capabilityNatives(input) {
+		define(native.name, native.arity, native.fn)
+	}
+}
+
+// startTime anchors clock. time.Since reads the monotonic clock, so the
+// difference of two clock() calls is unaffected by changes to the wall
+// clock.
+var startTime = time.Now()
+
+// clock returns the seconds elapsed since the program started, with
+// sub-microsecond precision.
+func clock(args []Value) (Value, error) {
+	return time.Since(startTime).Seconds(), nil
+}
+
+// lineInput is the source of the input native. SetInput on a backend
+// replaces its reader.
+type lineInput struct {
+	reader *bufio.Reader
+}
+
+func newLineInput(in io.Reader) *lineInput {
+	return &lineInput{reader: bufio.NewReader(in)}
+}
+
+// readLine returns the next line without its line ending, or nil at the
+// end of the input.
+func (in *lineInput) readLine(args []Value) (Value, error) {
+	line, err := in.reader.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// numberArg and stringArg return argument idx of the native name, or an
+// error naming what it should have been.
+func numberArg(name string, args []Value, idx int) (float64, error) {
+	n, ok := args[idx].(float64)
+	if !ok {
+		return 0, fmt.Errorf("Argument %d of '%s' must be a number.", idx+1, name)
+	}
+	return n, nil
+}
+
+func stringArg(name string, args []Value, idx int) (string, error) {
+	s, ok := args[idx].(string)
+	if !ok {
+		return "", fmt.Errorf("Argument %d of '%s' must be a string.", idx+1, name)
+	}
+	return s, nil
+}
+
+func mathFunc(name string, fn func(float64) float64) NativeFunc {
+	return func(args []Value) (Value, error) {
+		x, err := numberArg(name, args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fn(x), nil
+	}
+}
+
+func mathFunc2(name string, fn func(float64, float64) float64) NativeFunc {
+	return func(args []Value) (Value, error) {
+		x, err := numberArg(name, args, 0)
+		if err != nil {
+			return nil, err
+		}
+		y, err := numberArg(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fn(x, y), nil
+	}
+}
+
+func stringFunc(name string, fn func(string) string) NativeFunc {
+	return func(args []Value) (Value, error) {
+		s, err := stringArg(name, args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return fn(s), nil
+	}
+}
+
+// typeOf is the type native: the name of the kind of value its argument
+// is, the same on both backends.
+func typeOf(args []Value) (Value, error) {
+	switch args[0].(type) {
+	case nil:
+		return "nil", nil
+	case bool:
+		return "boolean", nil
+	case float64:
+		return "number", nil
+	case string:
+		return "string", nil
+	case *LoxList:
+		return "list", nil
+	case *LoxMap:
+		return "map", nil
+	case *LoxClass, *vmClass:
+		return "class", nil
+	case *LoxInstance, *vmInstance:
+		return "instance", nil
+	case *LoxError:
+		return "error", nil
+	case LoxCallable, *vmClosure, *vmBoundMethod:
+		return "function", nil
+	}
+	return "object", nil
+}
+
+// str converts its argument to the string print would show.
+func str(args []Value) (Value, error) {
+	return stringify(args[0]), nil
+}
+
+// numberSyntax is what num accepts: a decimal number with an optional sign,
+// fraction and exponent.
+var numberSyntax = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+
+// num parses a string as a number, returning nil when it is not one.
+// Surrounding whitespace is ignored and numbers are returned unchanged.
+func num(args []Value) (Value, error) {
+	if n, ok := args[0].(float64); ok {
+		return n, nil
+	}
+	s, err := stringArg("num", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.TrimSpace(s)
+	if !numberSyntax.MatchString(s) {
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		// Only out of range values get here.
+		return nil, nil
+	}
+	return n, nil
+}
+
+// substr returns the characters of a string from start up to but not
+// including end.
+func substr(args []Value) (Value, error) {
+	s, err := stringArg("substr", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	start, err := numberArg("substr", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	end, err := numberArg("substr", args, 2)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	if start != math.Trunc(start) || end != math.Trunc(end) {
+		return nil, errors.New("Substring bounds must be whole numbers.")
+	}
+	if start < 0 || end < start || end > float64(len(runes)) {
+		return nil, fmt.Errorf("Substring %s to %s out of range for length %d.",
+			stringify(start), stringify(end), len(runes))
+	}
+	return string(runes[int(start):int(end)]), nil
+}
+
+// split returns the parts of a string between occurrences of a separator,
+// or its characters when the separator is empty.
+func split(args []Value) (Value, error) {
+	s, err := stringArg("split", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg("split", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s, sep)
+	elements := make([]Value, len(parts))
+	for idx, part := range parts {
+		elements[idx] = part
+	}
+	return NewLoxList(elements), nil
+}
+
+// format replaces each {} in a template with the next element of a list,
+// converted as str does.
+func format(args []Value) (Value, error) {
+	template, err := stringArg("format", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := args[1].(*LoxList)
+	if !ok {
+		return nil, errors.New("Argument 2 of 'format' must be a list.")
+	}
+	parts := strings.Split(template, "{}")
+	if len(parts)-1 != len(values.elements) {
+		return nil, fmt.Errorf("Format string has %d placeholders but got %d values.",
+			len(parts)-1, len(values.elements))
+	}
+	var b strings.Builder
+	for idx, part := range parts {
+		b.WriteString(part)
+		if idx < len(values.elements) {
+			b.WriteString(stringify(values.elements[idx]))
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,75 @@
+package lox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestStdlib_ArgumentErrors(t *testing.T) {
+	progs := []struct {
+		prog string
+		msg  string
+	}{
+		{`abs("1");`, "Argument 1 of 'abs' must be a number."},
+		{`pow(2, nil);`, "Argument 2 of 'pow' must be a number."},
+		{`upper(1);`, "Argument 1 of 'upper' must be a string."},
+		{`split("a", 1);`, "Argument 2 of 'split' must be a string."},
+		{`num(true);`, "Argument 1 of 'num' must be a string."},
+		{`substr("abc", 0.5, 1);`, "Substring bounds must be whole numbers."},
+		{`substr("abc", 2, 4);`, "Substring 2 to 4 out of range for length 3."},
+		{`substr("abc", 2, 1);`, "Substring 2 to 1 out of range for length 3."},
+		{`format("{}", 1);`, "Argument 2 of 'format' must be a list."},
+		{`format("{} {}", [1]);`, "Format string has 2 placeholders but got 1 values."},
+		{`len(1);`, "Only lists, maps and strings have a length."},
+		{`type();`, "Expected 1 arguments but got 0."},
+	}
+	for name, kind := range backends {
+		for _, tc := range progs {
+			err := NewBackendWithOutput(kind, ioutil.Discard, ioutil.Discard).Interpret(parse(t, tc.prog))
+			rerr, ok := err.(*RuntimeError)
+			if !ok || rerr.Message != tc.msg {
+				t.Errorf("%s: %s: expected %q, got %v", name, tc.prog, tc.msg, err)
+			}
+		}
+	}
+}
+
+func TestStdlib_Input(t *testing.T) {
+	prog := `var line = input();
+	while (line != nil) {
+		print upper(line);
+		line = input();
+	}`
+	for name, kind := range backends {
+		var out bytes.Buffer
+		backend := NewBackendWithOutput(kind, &out, ioutil.Discard)
+		backend.SetInput(strings.NewReader("one\r\ntwo\nthree"))
+		if err := backend.Interpret(parse(t, prog)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := out.String(); got != "ONE\nTWO\nTHREE\n" {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+}
+
+func TestStdlib_Clock(t *testing.T) {
+	prog := `var start = clock();
+	var i = 0;
+	while (i < 10000) i = i + 1;
+	var elapsed = clock() - start;`
+	for name, kind := range backends {
+		backend := NewBackendWithOutput(kind, ioutil.Discard, ioutil.Discard)
+		if err := backend.Interpret(parse(t, prog)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		elapsed, _ := backend.Get("elapsed")
+		// The loop takes well under a second, which a clock counting whole
+		// seconds would report as zero or less.
+		if seconds, ok := elapsed.(float64); !ok || seconds <= 0 || seconds >= 1 {
+			t.Errorf("%s: expected a fraction of a second, got %v", name, elapsed)
+		}
+	}
+}
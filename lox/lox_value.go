@@ -0,0 +1,100 @@
+package lox
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Value is any value a Lox program can hold: nil, bool, float64, string, or
+// one of the callable and instance types of this package.
+type Value = interface{}
+
+// toLoxValue converts Go numbers to the float64 Lox computes with.
+func toLoxValue(value Value) Value {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
+
+// stringify renders a value the way print shows it. Whole numbers print
+// without a fractional part, so 3 prints as "3" rather than "3.0".
+func stringify(value Value) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// add implements the + operator. Two numbers are summed; when either operand
+// is a string the other one is stringified and the two are concatenated.
+// Any other combination is an error, reported by returning false.
+func add(left Value, right Value) (Value, bool) {
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			return l + r, true
+		}
+	}
+	_, lstring := left.(string)
+	_, rstring := right.(string)
+	if lstring || rstring {
+		return stringify(left) + stringify(right), true
+	}
+	return nil, false
+}
+
+// isTruthy reports whether a value counts as true in a condition. Only nil
+// and false are falsey; 0, "" and every object are truthy.
+func isTruthy(value Value) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// isEqual implements == and !=. Values of different types are never equal,
+// so 1 == "1" is false and nil only equals nil. Numbers compare by IEEE
+// value, which makes NaN unequal to itself; strings and booleans compare by
+// value. Instances, classes and functions are equal only to themselves.
+func isEqual(left Value, right Value) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if reflect.TypeOf(left) != reflect.TypeOf(right) {
+		return false
+	}
+	// Host values Go cannot compare, such as slices, have no identity to test.
+	return reflect.TypeOf(left).Comparable() && left == right
+}
@@ -15,6 +15,7 @@ type Visitor interface {
 	VisitBlockStmt(b *BlockStmt) interface{}
 	VisitVariableStmt(s *VariableStmt) interface{}
 	VisitWhileStmt(i *WhileStmt) interface{}
+	VisitForStmt(f *ForStmt) interface{}
 	VisitFunctionStmt(f *FunctionStmt) interface{}
 	VisitReturnStmt(r *ReturnStmt) interface{}
 	VisitExprStmt(e *ExprStmt) interface{}
@@ -22,4 +23,15 @@ type Visitor interface {
 	VisitClassStmt(c *ClassStmt) interface{}
 	VisitSetExpr(s *SetExpr) interface{}
 	VisitSuperExpr(e *SuperExpr) interface{}
+	VisitListExpr(l *ListExpr) interface{}
+	VisitMapExpr(m *MapExpr) interface{}
+	VisitIndexExpr(i *IndexExpr) interface{}
+	VisitSetIndexExpr(s *SetIndexExpr) interface{}
+	VisitFunctionExpr(f *FunctionExpr) interface{}
+	VisitForInStmt(f *ForInStmt) interface{}
+	VisitBreakStmt(b *BreakStmt) interface{}
+	VisitContinueStmt(c *ContinueStmt) interface{}
+	VisitImportStmt(i *ImportStmt) interface{}
+	VisitThrowStmt(t *ThrowStmt) interface{}
+	VisitTryStmt(t *TryStmt) interface{}
 }
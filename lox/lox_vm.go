@@ -0,0 +1,935 @@
+package lox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The value stack starts out at stackInit slots and grows as values are
+// pushed. A call that finds more than stackMax slots in use is a stack
+// overflow, however few frames are active.
+const (
+	stackInit = 256
+	stackMax  = 1 << 20
+)
+
+// vmFunction is a compiled function. The top-level script and anonymous
+// functions both have an empty name.
+type vmFunction struct {
+	name         string
+	anonymous    bool
+	arity        int
+	upvalueCount int
+	chunk        *Chunk
+}
+
+// vmClosure is a function with its captured variables and the globals of
+// the module that created it.
+type vmClosure struct {
+	function *vmFunction
+	upvalues []*vmUpvalue
+	globals  map[string]Value
+}
+
+// vmImport is the operand of OpImport: the module path and the file of the
+// script importing it, which relative paths start from.
+type vmImport struct {
+	path     string
+	importer string
+}
+
+// vmUpvalue is a variable captured by a closure. While the variable is still
+// on the stack, location points at its slot; when the slot is popped the
+// value moves into closed and location points there instead.
+type vmUpvalue struct {
+	location *Value
+	closed   Value
+	slot     int
+	next     *vmUpvalue
+}
+
+type vmClass struct {
+	name    string
+	methods map[string]*vmClosure
+}
+
+type vmInstance struct {
+	class  *vmClass
+	fields map[string]Value
+}
+
+type vmBoundMethod struct {
+	receiver Value
+	method   *vmClosure
+}
+
+type callFrame struct {
+	closure *vmClosure
+	ip      int
+	slots   int
+}
+
+// tryHandler is a handler pushed by OpTryCatch or OpTryFinally: the frame
+// and stack height to unwind to and the instruction to go on at.
+type tryHandler struct {
+	frame   int
+	sp      int
+	target  int
+	finally bool
+}
+
+// VM executes Lox programs compiled to bytecode by the Compiler. It is the
+// alternative to the tree-walking Interpreter and exposes the same Backend
+// API; globals and natives persist across calls to Interpret.
+type VM struct {
+	stack        []Value
+	sp           int
+	frames       []*callFrame
+	frameCount   int
+	maxFrames    int
+	globals      map[string]Value
+	openUpvalues *vmUpvalue
+	handlers     []tryHandler
+	out          io.Writer
+	diagnostics  io.Writer
+	input        *lineInput
+	natives      []*NativeFunction
+	modules      *moduleLoader
+	budget       budget
+	capabilities Capability
+}
+
+// NewVM returns a VM that prints to os.Stdout and reports errors to
+// os.Stderr.
+func NewVM() *VM {
+	return NewVMWithOutput(os.Stdout, os.Stderr)
+}
+
+// NewVMWithOutput returns a VM whose print statements write to out and which
+// reports every error Interpret returns to diagnostics.
+func NewVMWithOutput(out io.Writer, diagnostics io.Writer) *VM {
+	vm := &VM{
+		stack:        make([]Value, stackInit),
+		globals:      make(map[string]Value),
+		maxFrames:    DefaultMaxCallDepth,
+		out:          out,
+		diagnostics:  diagnostics,
+		input:        newLineInput(os.Stdin),
+		modules:      newModuleLoader(),
+		capabilities: AllCapabilities,
+	}
+	defineStdlib(vm.DefineNative, vm.input)
+	return vm
+}
+
+// Interpret checks statements with the LoxResolver, compiles them and runs
+// the result. Errors are also written to the diagnostics writer.
+func (vm *VM) Interpret(statements []Stmt) error {
+	return vm.InterpretContext(context.Background(), statements)
+}
+
+// InterpretContext is Interpret for a program that stops with a *LimitError
+// once ctx is done.
+func (vm *VM) InterpretContext(ctx context.Context, statements []Stmt) error {
+	err := vm.interpret(ctx, statements)
+	if err != nil {
+		fmt.Fprintln(vm.diagnostics, err)
+	}
+	return err
+}
+
+func (vm *VM) interpret(ctx context.Context, statements []Stmt) error {
+	if err := NewResolver(nil).Resolve(statements); err != nil {
+		return err
+	}
+	fn, err := NewCompiler().Compile(statements)
+	if err != nil {
+		return err
+	}
+
+	vm.budget.start(ctx)
+	closure := &vmClosure{function: fn, globals: vm.globals}
+	vm.push(closure)
+	if err := vm.call(closure, 0); err != nil {
+		return err
+	}
+	return vm.run(0)
+}
+
+func (vm *VM) DefineNative(name string, arity int, fn NativeFunc) {
+	vm.defineNative(NewNativeFunction(name, arity, fn))
+}
+
+func (vm *VM) DefineNativeCallback(name string, arity int, fn NativeCallbackFunc) {
+	vm.defineNative(NewNativeCallbackFunction(name, arity, fn))
+}
+
+// defineNative defines native as a global of the program and of every
+// module it imports.
+func (vm *VM) defineNative(native *NativeFunction) {
+	vm.globals[native.name] = native
+	vm.natives = append(vm.natives, native)
+}
+
+func (vm *VM) SetSearchPath(dirs ...string) {
+	vm.modules.searchPath = dirs
+}
+
+// SetMaxCallDepth limits how many calls may be active at once, counting
+// the script itself; a call beyond it is a "Stack overflow." runtime error.
+// A depth below one restores DefaultMaxCallDepth.
+func (vm *VM) SetMaxCallDepth(depth int) {
+	if depth < 1 {
+		depth = DefaultMaxCallDepth
+	}
+	vm.maxFrames = depth
+}
+
+// SetLimits bounds the work each program Interpret runs may do.
+func (vm *VM) SetLimits(limits Limits) {
+	vm.budget.limits = limits
+	vm.SetMaxCallDepth(limits.MaxCallDepth)
+}
+
+// SetCapabilities withholds the natives and statements of every capability
+// not in caps, and grants back those in it.
+func (vm *VM) SetCapabilities(caps Capability) {
+	vm.capabilities = caps
+	for _, native := range capabilityNatives(vm.input) {
+		vm.withdrawNative(native.name)
+		if caps&native.capability != 0 {
+			vm.DefineNative(native.name, native.arity, native.fn)
+		}
+	}
+}
+
+// withdrawNative undoes defineNative for the native called name.
+func (vm *VM) withdrawNative(name string) {
+	delete(vm.globals, name)
+	natives := vm.natives[:0]
+	for _, native := range vm.natives {
+		if native.name != name {
+			natives = append(natives, native)
+		}
+	}
+	vm.natives = natives
+}
+
+func (vm *VM) SetInput(in io.Reader) {
+	vm.input.reader = bufio.NewReader(in)
+}
+
+func (vm *VM) Get(name string) (Value, bool) {
+	value, ok := vm.globals[name]
+	return value, ok
+}
+
+func (vm *VM) Set(name string, value Value) {
+	vm.globals[name] = toLoxValue(value)
+}
+
+// run executes instructions until the frame count drops back to base,
+// which is zero for a whole program. A runtime error or thrown value goes
+// to the innermost handler pushed since run started. Without one run
+// returns it, after resetting the VM when the whole program stops.
+func (vm *VM) run(base int) error {
+	for {
+		err := vm.execute(base)
+		if err == nil {
+			return nil
+		}
+		if vm.catch(err, base) {
+			continue
+		}
+		if base == 0 {
+			vm.reset()
+			if thrown, ok := err.(*thrownValue); ok {
+				return thrown.runtimeError()
+			}
+		}
+		return err
+	}
+}
+
+// execute runs instructions for run until the frame count drops back to
+// base or an error stops it.
+func (vm *VM) execute(base int) error {
+	frame := vm.frames[vm.frameCount-1]
+	code := frame.closure.function.chunk.Code
+	constants := frame.closure.function.chunk.Constants
+	globals := frame.closure.globals
+
+	readShort := func() int {
+		frame.ip += 2
+		return int(code[frame.ip-2])<<8 | int(code[frame.ip-1])
+	}
+	readString := func() string {
+		return constants[readShort()].(string)
+	}
+	refresh := func() {
+		frame = vm.frames[vm.frameCount-1]
+		code = frame.closure.function.chunk.Code
+		constants = frame.closure.function.chunk.Constants
+		globals = frame.closure.globals
+	}
+
+	for {
+		op := OpCode(code[frame.ip])
+		frame.ip++
+
+		switch op {
+		case OpConstant:
+			vm.push(constants[readShort()])
+		case OpNil:
+			vm.push(nil)
+		case OpTrue:
+			vm.push(true)
+		case OpFalse:
+			vm.push(false)
+		case OpPop:
+			vm.sp--
+		case OpGetLocal:
+			slot := int(code[frame.ip])
+			frame.ip++
+			vm.push(vm.stack[frame.slots+slot])
+		case OpSetLocal:
+			slot := int(code[frame.ip])
+			frame.ip++
+			vm.stack[frame.slots+slot] = vm.peek(0)
+		case OpGetGlobal:
+			name := readString()
+			value, ok := globals[name]
+			if !ok {
+				return vm.runtimeError("Undefined variable '%s'.", name)
+			}
+			vm.push(value)
+		case OpDefineGlobal:
+			globals[readString()] = vm.pop()
+		case OpSetGlobal:
+			name := readString()
+			if _, ok := globals[name]; !ok {
+				return vm.runtimeError("Undefined variable '%s'.", name)
+			}
+			globals[name] = vm.peek(0)
+		case OpGetUpvalue:
+			slot := code[frame.ip]
+			frame.ip++
+			vm.push(*frame.closure.upvalues[slot].location)
+		case OpSetUpvalue:
+			slot := code[frame.ip]
+			frame.ip++
+			*frame.closure.upvalues[slot].location = vm.peek(0)
+		case OpGetProperty:
+			name := readString()
+			instance, ok := vm.peek(0).(*vmInstance)
+			if !ok {
+				value, err := vm.property(vm.peek(0), name)
+				if err != nil {
+					return err
+				}
+				vm.stack[vm.sp-1] = value
+				break
+			}
+			if value, ok := instance.fields[name]; ok {
+				vm.stack[vm.sp-1] = value
+				break
+			}
+			if err := vm.bindMethod(instance.class, name); err != nil {
+				return err
+			}
+		case OpSetProperty:
+			instance, ok := vm.peek(1).(*vmInstance)
+			if !ok {
+				return vm.runtimeError("Only instances have fields.")
+			}
+			instance.fields[readString()] = vm.peek(0)
+			value := vm.pop()
+			vm.stack[vm.sp-1] = value
+		case OpGetSuper:
+			name := readString()
+			superclass := vm.pop().(*vmClass)
+			if err := vm.bindMethod(superclass, name); err != nil {
+				return err
+			}
+		case OpEqual:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(isEqual(a, b))
+		case OpGreater, OpGreaterEqual, OpLess, OpLessEqual, OpSubtract, OpMultiply, OpDivide:
+			b, bok := vm.peek(0).(float64)
+			a, aok := vm.peek(1).(float64)
+			if !aok || !bok {
+				return vm.runtimeError("Operands must be numbers.")
+			}
+			vm.sp--
+			vm.stack[vm.sp-1] = arithmetic(op, a, b)
+		case OpAdd:
+			if b, ok := vm.peek(0).(float64); ok {
+				if a, ok := vm.peek(1).(float64); ok {
+					vm.sp--
+					vm.stack[vm.sp-1] = a + b
+					break
+				}
+			}
+			sum, ok := add(vm.peek(1), vm.peek(0))
+			if !ok {
+				return vm.runtimeError("Operands must be two numbers or at least one string.")
+			}
+			if err := vm.budget.allocate(sum); err != nil {
+				return vm.limitError(err)
+			}
+			vm.sp--
+			vm.stack[vm.sp-1] = sum
+		case OpNot:
+			vm.stack[vm.sp-1] = !isTruthy(vm.peek(0))
+		case OpNegate:
+			value, ok := vm.peek(0).(float64)
+			if !ok {
+				return vm.runtimeError("Operand must be a number.")
+			}
+			vm.stack[vm.sp-1] = -value
+		case OpPrint:
+			fmt.Fprintln(vm.out, stringify(vm.pop()))
+		case OpJump:
+			offset := readShort()
+			frame.ip += offset
+		case OpJumpIfFalse:
+			offset := readShort()
+			if !isTruthy(vm.peek(0)) {
+				frame.ip += offset
+			}
+		case OpLoop:
+			offset := readShort()
+			if err := vm.budget.step(); err != nil {
+				return vm.limitError(err)
+			}
+			frame.ip -= offset
+		case OpCall:
+			argCount := int(code[frame.ip])
+			frame.ip++
+			if err := vm.callValue(vm.peek(argCount), argCount); err != nil {
+				return err
+			}
+			refresh()
+		case OpInvoke:
+			name := readString()
+			argCount := int(code[frame.ip])
+			frame.ip++
+			if err := vm.invoke(name, argCount); err != nil {
+				return err
+			}
+			refresh()
+		case OpSuperInvoke:
+			name := readString()
+			argCount := int(code[frame.ip])
+			frame.ip++
+			superclass := vm.pop().(*vmClass)
+			if err := vm.invokeFromClass(superclass, name, argCount); err != nil {
+				return err
+			}
+			refresh()
+		case OpClosure:
+			fn := constants[readShort()].(*vmFunction)
+			closure := &vmClosure{function: fn, upvalues: make([]*vmUpvalue, fn.upvalueCount), globals: globals}
+			if err := vm.budget.allocate(closure); err != nil {
+				return vm.limitError(err)
+			}
+			vm.push(closure)
+			for i := range closure.upvalues {
+				isLocal := code[frame.ip]
+				index := int(code[frame.ip+1])
+				frame.ip += 2
+				if isLocal == 1 {
+					closure.upvalues[i] = vm.captureUpvalue(frame.slots + index)
+				} else {
+					closure.upvalues[i] = frame.closure.upvalues[index]
+				}
+			}
+		case OpImport:
+			operand := constants[readShort()].(*vmImport)
+			if vm.capabilities&ImportCapability == 0 {
+				return vm.runtimeError("Imports are not allowed.")
+			}
+			module, err := vm.modules.load(operand.importer, operand.path, vm.runModule)
+			switch err.(type) {
+			case nil:
+			case *RuntimeError, *thrownValue, *LimitError:
+				// The module failed while running, at a line of its own.
+				return err
+			default:
+				return vm.runtimeError("%s", err.Error())
+			}
+			vm.push(module)
+		case OpImportAll:
+			module := vm.pop().(*LoxModule)
+			for name, value := range module.exports {
+				globals[name] = value
+			}
+		case OpThrow:
+			return throw(vm.pop(), vm.line(vm.frameCount-1), vm.stackTrace)
+		case OpRethrow:
+			return vm.pop().(*thrownValue)
+		case OpTryCatch, OpTryFinally:
+			offset := readShort()
+			vm.handlers = append(vm.handlers, tryHandler{
+				frame:   vm.frameCount - 1,
+				sp:      vm.sp,
+				target:  frame.ip + offset,
+				finally: op == OpTryFinally,
+			})
+		case OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case OpCloseUpvalue:
+			vm.closeUpvalues(vm.sp - 1)
+			vm.sp--
+		case OpReturn:
+			result := vm.pop()
+			vm.closeUpvalues(frame.slots)
+			vm.frameCount--
+			for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frame == vm.frameCount {
+				vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			}
+			if vm.frameCount == 0 {
+				vm.sp = 0
+				return nil
+			}
+			vm.sp = frame.slots
+			vm.push(result)
+			if vm.frameCount == base {
+				return nil
+			}
+			refresh()
+		case OpClass:
+			class := &vmClass{name: readString(), methods: make(map[string]*vmClosure)}
+			if err := vm.budget.allocate(class); err != nil {
+				return vm.limitError(err)
+			}
+			vm.push(class)
+		case OpInherit:
+			superclass, ok := vm.peek(1).(*vmClass)
+			if !ok {
+				return vm.runtimeError("Superclass must be a class.")
+			}
+			subclass := vm.peek(0).(*vmClass)
+			for name, method := range superclass.methods {
+				subclass.methods[name] = method
+			}
+			vm.sp--
+		case OpMethod:
+			class := vm.peek(1).(*vmClass)
+			class.methods[readString()] = vm.peek(0).(*vmClosure)
+			vm.sp--
+		case OpList:
+			count := readShort()
+			elements := make([]Value, count)
+			copy(elements, vm.stack[vm.sp-count:vm.sp])
+			vm.sp -= count
+			list := NewLoxList(elements)
+			if err := vm.budget.allocate(list); err != nil {
+				return vm.limitError(err)
+			}
+			vm.push(list)
+		case OpMap:
+			count := readShort()
+			result := NewLoxMap()
+			for i := vm.sp - 2*count; i < vm.sp; i += 2 {
+				if err := result.Set(vm.stack[i], vm.stack[i+1]); err != nil {
+					return vm.runtimeError("%s", err.Error())
+				}
+			}
+			vm.sp -= 2 * count
+			if err := vm.budget.allocate(result); err != nil {
+				return vm.limitError(err)
+			}
+			vm.push(result)
+		case OpGetIndex:
+			value, err := getIndex(vm.peek(1), vm.peek(0))
+			if err != nil {
+				return vm.runtimeError("%s", err.Error())
+			}
+			vm.sp--
+			vm.stack[vm.sp-1] = value
+		case OpSetIndex:
+			if err := setIndex(vm.peek(2), vm.peek(1), vm.peek(0)); err != nil {
+				return vm.runtimeError("%s", err.Error())
+			}
+			value := vm.pop()
+			vm.sp--
+			vm.stack[vm.sp-1] = value
+		case OpIterable:
+			// A list is iterated live; anything else as a snapshot list.
+			if _, ok := vm.peek(0).(*LoxList); !ok {
+				elements, err := iterate(vm.peek(0))
+				if err != nil {
+					return vm.runtimeError("%s", err.Error())
+				}
+				vm.stack[vm.sp-1] = NewLoxList(elements)
+			}
+		case OpForIter:
+			slot := frame.slots + int(code[frame.ip])
+			frame.ip++
+			offset := readShort()
+			sequence := vm.stack[slot].(*LoxList)
+			index := int(vm.stack[slot+1].(float64))
+			if index >= len(sequence.elements) {
+				frame.ip += offset
+				break
+			}
+			vm.stack[slot+1] = float64(index + 1)
+			vm.push(sequence.elements[index])
+		default:
+			return vm.runtimeError("Unknown opcode %d.", op)
+		}
+	}
+}
+
+func arithmetic(op OpCode, a, b float64) Value {
+	switch op {
+	case OpGreater:
+		return a > b
+	case OpGreaterEqual:
+		return a >= b
+	case OpLess:
+		return a < b
+	case OpLessEqual:
+		return a <= b
+	case OpSubtract:
+		return a - b
+	case OpMultiply:
+		return a * b
+	default:
+		return a / b
+	}
+}
+
+func (vm *VM) push(value Value) {
+	if vm.sp == len(vm.stack) {
+		vm.growStack()
+	}
+	vm.stack[vm.sp] = value
+	vm.sp++
+}
+
+// growStack doubles the stack. The open upvalues point into it, so they
+// are moved to the new one.
+func (vm *VM) growStack() {
+	stack := make([]Value, 2*len(vm.stack))
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+	for upvalue := vm.openUpvalues; upvalue != nil; upvalue = upvalue.next {
+		upvalue.location = &vm.stack[upvalue.slot]
+	}
+}
+
+func (vm *VM) pop() Value {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) peek(distance int) Value {
+	return vm.stack[vm.sp-1-distance]
+}
+
+func (vm *VM) callValue(callee Value, argCount int) error {
+	if err := vm.budget.step(); err != nil {
+		return vm.limitError(err)
+	}
+	switch callee := callee.(type) {
+	case *vmClosure:
+		return vm.call(callee, argCount)
+	case *vmBoundMethod:
+		vm.stack[vm.sp-argCount-1] = callee.receiver
+		return vm.call(callee.method, argCount)
+	case *vmClass:
+		instance := &vmInstance{class: callee, fields: make(map[string]Value)}
+		if err := vm.budget.allocate(instance); err != nil {
+			return vm.limitError(err)
+		}
+		vm.stack[vm.sp-argCount-1] = instance
+		if initializer, ok := callee.methods["init"]; ok {
+			return vm.call(initializer, argCount)
+		}
+		if argCount != 0 {
+			return vm.runtimeError("Expected 0 arguments but got %d.", argCount)
+		}
+		return nil
+	case *NativeFunction:
+		if argCount != callee.arity {
+			return vm.runtimeError("Expected %d arguments but got %d.", callee.arity, argCount)
+		}
+		args := make([]Value, argCount)
+		copy(args, vm.stack[vm.sp-argCount:vm.sp])
+		result, err := callee.invoke(vm.callback, args)
+		switch err.(type) {
+		case nil:
+		case *RuntimeError, *thrownValue, *LimitError:
+			// It was raised by a function the native called back.
+			return err
+		default:
+			return vm.runtimeError("%s", err.Error())
+		}
+		if err := vm.budget.allocate(result); err != nil {
+			return vm.limitError(err)
+		}
+		vm.sp -= argCount + 1
+		vm.push(result)
+		return nil
+	}
+	return vm.runtimeError("Can only call functions and classes.")
+}
+
+// runModule compiles the statements of a module and runs them in globals
+// of its own, which start out holding only the natives.
+func (vm *VM) runModule(statements []Stmt) (func(name string) Value, error) {
+	if err := NewResolver(nil).Resolve(statements); err != nil {
+		return nil, err
+	}
+	fn, err := NewCompiler().Compile(statements)
+	if err != nil {
+		return nil, err
+	}
+	globals := make(map[string]Value)
+	for _, native := range vm.natives {
+		globals[native.name] = native
+	}
+	if _, err := vm.callback(&vmClosure{function: fn, globals: globals}); err != nil {
+		return nil, err
+	}
+	return func(name string) Value {
+		return globals[name]
+	}, nil
+}
+
+// callback is the Caller natives get. It runs the called function to
+// completion on top of the frames already active.
+func (vm *VM) callback(callee Value, args ...Value) (Value, error) {
+	base := vm.frameCount
+	vm.push(callee)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	if err := vm.callValue(callee, len(args)); err != nil {
+		return nil, err
+	}
+	if vm.frameCount > base {
+		if err := vm.run(base); err != nil {
+			return nil, err
+		}
+	}
+	return vm.pop(), nil
+}
+
+func (vm *VM) call(closure *vmClosure, argCount int) error {
+	if argCount != closure.function.arity {
+		return vm.runtimeError("Expected %d arguments but got %d.", closure.function.arity, argCount)
+	}
+	if vm.frameCount >= vm.maxFrames || vm.sp > stackMax {
+		return vm.runtimeError("Stack overflow.")
+	}
+
+	if vm.frameCount == len(vm.frames) {
+		vm.frames = append(vm.frames, &callFrame{})
+	}
+	frame := vm.frames[vm.frameCount]
+	vm.frameCount++
+	frame.closure = closure
+	frame.ip = 0
+	frame.slots = vm.sp - argCount - 1
+	return nil
+}
+
+func (vm *VM) invoke(name string, argCount int) error {
+	instance, ok := vm.peek(argCount).(*vmInstance)
+	if !ok {
+		value, err := vm.property(vm.peek(argCount), name)
+		if err != nil {
+			return err
+		}
+		vm.stack[vm.sp-argCount-1] = value
+		return vm.callValue(value, argCount)
+	}
+	if value, ok := instance.fields[name]; ok {
+		vm.stack[vm.sp-argCount-1] = value
+		return vm.callValue(value, argCount)
+	}
+	return vm.invokeFromClass(instance.class, name, argCount)
+}
+
+// property looks up a property of a value other than an instance: an
+// export of a module or a native method of a list or map.
+func (vm *VM) property(object Value, name string) (Value, error) {
+	switch o := object.(type) {
+	case *LoxModule:
+		if value, ok := o.Get(name); ok {
+			return value, nil
+		}
+		return nil, vm.runtimeError("Undefined property '%s'.", name)
+	case *LoxError:
+		if value, ok := o.Get(name); ok {
+			return value, nil
+		}
+		return nil, vm.runtimeError("Undefined property '%s'.", name)
+	case *LoxList, *LoxMap:
+		if method, ok := collectionMethod(object, name); ok {
+			return method, nil
+		}
+		return nil, vm.runtimeError("Undefined property '%s'.", name)
+	}
+	return nil, vm.runtimeError("Only instances have properties.")
+}
+
+func (vm *VM) invokeFromClass(class *vmClass, name string, argCount int) error {
+	method, ok := class.methods[name]
+	if !ok {
+		return vm.runtimeError("Undefined property '%s'.", name)
+	}
+	if err := vm.budget.step(); err != nil {
+		return vm.limitError(err)
+	}
+	return vm.call(method, argCount)
+}
+
+func (vm *VM) bindMethod(class *vmClass, name string) error {
+	method, ok := class.methods[name]
+	if !ok {
+		return vm.runtimeError("Undefined property '%s'.", name)
+	}
+	vm.stack[vm.sp-1] = &vmBoundMethod{receiver: vm.peek(0), method: method}
+	return nil
+}
+
+func (vm *VM) captureUpvalue(slot int) *vmUpvalue {
+	var prev *vmUpvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.slot > slot {
+		prev = upvalue
+		upvalue = upvalue.next
+	}
+	if upvalue != nil && upvalue.slot == slot {
+		return upvalue
+	}
+
+	created := &vmUpvalue{location: &vm.stack[slot], slot: slot, next: upvalue}
+	if prev == nil {
+		vm.openUpvalues = created
+	} else {
+		prev.next = created
+	}
+	return created
+}
+
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = *upvalue.location
+		upvalue.location = &upvalue.closed
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+// runtimeError builds the error for the instruction being executed, with
+// the stack trace of the active frames.
+func (vm *VM) runtimeError(format string, args ...interface{}) error {
+	line := vm.line(vm.frameCount - 1)
+	return &RuntimeError{Line: line, Message: fmt.Sprintf(format, args...), Stack: vm.stackTrace(line)}
+}
+
+// limitError fills in where err stopped the instruction being executed.
+func (vm *VM) limitError(err *LimitError) error {
+	err.Line = vm.line(vm.frameCount - 1)
+	err.Stack = vm.stackTrace(err.Line)
+	return err
+}
+
+// line returns the line of the instruction frame idx is executing.
+func (vm *VM) line(idx int) int {
+	frame := vm.frames[idx]
+	return frame.closure.function.chunk.Lines[frame.ip-1]
+}
+
+// reset empties the stack so the VM can run another program.
+func (vm *VM) reset() {
+	vm.sp = 0
+	vm.frameCount = 0
+	vm.openUpvalues = nil
+	vm.handlers = vm.handlers[:0]
+}
+
+// catch hands err to the innermost handler pushed since run(base) started
+// and reports whether there was one. The frames and stack above the
+// handler are unwound and the value it gets is pushed: the thrown value
+// for a catch clause, the exception itself for finally.
+func (vm *VM) catch(err error, base int) bool {
+	n := len(vm.handlers)
+	if n == 0 || vm.handlers[n-1].frame < base {
+		return false
+	}
+	thrown, ok := err.(*thrownValue)
+	if !ok {
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			return false
+		}
+		thrown = raised(runtimeErr)
+	}
+
+	handler := vm.handlers[n-1]
+	vm.handlers = vm.handlers[:n-1]
+	vm.closeUpvalues(handler.sp)
+	vm.frameCount = handler.frame + 1
+	vm.sp = handler.sp
+	if handler.finally {
+		vm.push(thrown)
+	} else {
+		vm.push(thrown.value)
+	}
+	vm.frames[handler.frame].ip = handler.target
+	return true
+}
+
+// stackTrace lists the active frames, innermost first, with the innermost
+// one at line.
+func (vm *VM) stackTrace(line int) []string {
+	trace := make([]string, 0, vm.frameCount)
+	for idx := vm.frameCount - 1; idx >= 0; idx-- {
+		fn := vm.frames[idx].closure.function
+		if idx < vm.frameCount-1 {
+			line = vm.line(idx)
+		}
+		trace = append(trace, traceEntry(fn.name, fn.anonymous, line))
+	}
+	return trace
+}
+
+func (f *vmFunction) String() string {
+	if f.anonymous {
+		return "<fn>"
+	}
+	if f.name == "" {
+		return "<script>"
+	}
+	return "<fn " + f.name + ">"
+}
+
+func (i *vmImport) String() string {
+	return i.path
+}
+
+func (c *vmClosure) String() string {
+	return c.function.String()
+}
+
+func (b *vmBoundMethod) String() string {
+	return b.method.String()
+}
+
+func (c *vmClass) String() string {
+	return c.name
+}
+
+func (i *vmInstance) String() string {
+	return i.class.name + " instance"
+}
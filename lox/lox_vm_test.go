@@ -0,0 +1,358 @@
+package lox
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var backends = map[string]BackendKind{
+	"tree-walker": TreeWalker,
+	"bytecode":    Bytecode,
+}
+
+// backendCorpus holds programs both backends must agree on. Each program
+// leaves its result in the global "result".
+var backendCorpus = []struct {
+	name   string
+	prog   string
+	result Value
+}{
+	{"arithmetic", `var result = 1 + 2 * 3 - 4 / 2;`, 5.0},
+	{"grouping", `var result = -(1 + 2) * 3;`, -9.0},
+	{"globals", `var a = 1; a = a + 1; var result = a;`, 2.0},
+	{"blocks", `var a = "global";
+		var result;
+		{
+			var a = "local";
+			{
+				result = a;
+			}
+		}`, "local"},
+	{"closures", `fun makeCounter() {
+			var count = 0;
+			fun inc() {
+				count = count + 1;
+				return count;
+			}
+			return inc;
+		}
+		var counter = makeCounter();
+		counter();
+		counter();
+		var result = counter();`, 3.0},
+	{"upvalue chain", `fun outer() {
+			var x = 1;
+			fun middle() {
+				fun inner() {
+					x = x + 1;
+					return x;
+				}
+				return inner;
+			}
+			return middle;
+		}
+		var result = outer()()();`, 2.0},
+	{"nested return", `fun pick(a, b) {
+			{
+				var sum = a + b;
+				{
+					return sum;
+				}
+			}
+		}
+		var result = pick(3, 4);`, 7.0},
+	{"native", `var result = twice(21);`, 42.0},
+	{"concatenation", `var result = "con" + "cat";`, "concat"},
+	{"mixed concatenation", `var result = "n=" + 1 + ", half=" + 0.5 + ", " + true + " " + nil;`,
+		"n=1, half=0.5, true nil"},
+	{"number first", `var result = 1 + 2 + "3";`, "33"},
+	{"escapes", `var result = "a\tb\u{21}";`, "a\tb!"},
+}
+
+func newTestBackend(kind BackendKind) Backend {
+	backend := NewBackendWithOutput(kind, ioutil.Discard, ioutil.Discard)
+	backend.DefineNative("twice", 1, func(args []Value) (Value, error) {
+		return args[0].(float64) * 2, nil
+	})
+	return backend
+}
+
+func parse(t testing.TB, prog string) []Stmt {
+	lexer := NewScanner()
+	if err := lexer.Eval(prog); err != nil {
+		t.Fatal(err)
+	}
+	ast, err := NewParser(lexer.Tokens).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ast
+}
+
+func TestBackends_Corpus(t *testing.T) {
+	for name, kind := range backends {
+		for _, tc := range backendCorpus {
+			backend := newTestBackend(kind)
+			if err := backend.Interpret(parse(t, tc.prog)); err != nil {
+				t.Fatalf("%s/%s: %v", name, tc.name, err)
+			}
+			result, _ := backend.Get("result")
+			if result != tc.result {
+				t.Errorf("%s/%s: expected %v, got %v", name, tc.name, tc.result, result)
+			}
+		}
+	}
+}
+
+func TestBackends_RuntimeErrors(t *testing.T) {
+	progs := []struct {
+		prog string
+		msg  string
+	}{
+		{"var a = 1;\na();", "Can only call functions and classes."},
+		{"fun f(a, b) {}\nf(1);", "Expected 2 arguments but got 1."},
+		{"var a = 1;\nprint b;", "Undefined variable 'b'."},
+		{"var a = 1;\nb = 2;", "Undefined variable 'b'."},
+		{"var a = 1;\nprint nil + a;", "Operands must be two numbers or at least one string."},
+		{"var a = 1;\nprint a - \"x\";", "Operands must be numbers."},
+		{"var a = 1;\nprint -\"x\";", "Operand must be a number."},
+		{"var a = [];\na.pop();", "Can't pop from an empty list."},
+		{"var a = [];\na.size();", "Undefined property 'size'."},
+		{"var a = {};\nprint a[nil];", "Map keys must be strings, numbers or booleans."},
+		{"var a = [1];\nprint a[0.5];", "List index must be a whole number."},
+		{"var a = 1;\nfor (var x in a) print x;", "Can only iterate over lists and maps."},
+		{"var a = [1];\na.map(1);", "Can only call functions and classes."},
+		{"var a = [1];\na.filter(fun (x, y) {});", "Expected 2 arguments but got 1."},
+		{"var a = [1];\na.map(x => -\"x\");", "Operand must be a number."},
+	}
+	for name, kind := range backends {
+		for _, tc := range progs {
+			err := NewBackendWithOutput(kind, ioutil.Discard, ioutil.Discard).Interpret(parse(t, tc.prog))
+			rerr, ok := err.(*RuntimeError)
+			if !ok {
+				t.Fatalf("%s: expected *RuntimeError for %q, got %v", name, tc.prog, err)
+			}
+			if rerr.Message != tc.msg || rerr.Line != 2 {
+				t.Errorf("%s: expected %q on line 2, got %q on line %d", name, tc.msg, rerr.Message, rerr.Line)
+			}
+		}
+	}
+}
+
+func TestBackends_DefineNativeCallback(t *testing.T) {
+	prog := `var result = apply(fun (a, b) { return a * b; }, 6, 7);
+	var again = apply(apply, fun (x, y) { return x + y; }, 1);`
+	for name, kind := range backends {
+		backend := newTestBackend(kind)
+		backend.DefineNativeCallback("apply", 3, func(call Caller, args []Value) (Value, error) {
+			return call(args[0], args[1], args[2])
+		})
+		err := backend.Interpret(parse(t, prog))
+		rerr, ok := err.(*RuntimeError)
+		if !ok || rerr.Message != "Expected 3 arguments but got 2." || rerr.Line != 2 {
+			t.Fatalf("%s: expected an arity error on line 2, got %v", name, err)
+		}
+		if result, _ := backend.Get("result"); result != 42.0 {
+			t.Errorf("%s: expected 42, got %v", name, result)
+		}
+
+		// The backend must still run programs after a failed callback.
+		if err := backend.Interpret(parse(t, `var after = apply(twice, 2, nil);`)); err == nil {
+			t.Fatalf("%s: expected an arity error from twice", name)
+		}
+		if err := backend.Interpret(parse(t, `var after = [1, 2].map(twice);`)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if after, _ := backend.Get("after"); after.(*LoxList).String() != "[2, 4]" {
+			t.Errorf("%s: expected [2, 4], got %v", name, after)
+		}
+	}
+}
+
+func TestBackends_Exceptions(t *testing.T) {
+	prog := `var result;
+	try { fail(); } catch (e) { result = e.message + " on line " + str(e.line); }`
+	for name, kind := range backends {
+		backend := newTestBackend(kind)
+		backend.DefineNative("fail", 0, func(args []Value) (Value, error) {
+			return nil, errors.New("Native failed.")
+		})
+		if err := backend.Interpret(parse(t, prog)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result, _ := backend.Get("result"); result != "Native failed. on line 2" {
+			t.Errorf("%s: unexpected result %v", name, result)
+		}
+
+		err := backend.Interpret(parse(t, "fun f() {\n  throw 1;\n}\nf();"))
+		rerr, ok := err.(*RuntimeError)
+		if !ok || rerr.Message != "Uncaught 1." || rerr.Line != 2 {
+			t.Fatalf("%s: expected an uncaught exception on line 2, got %v", name, err)
+		}
+		// The backend must still run programs after an uncaught exception.
+		if err := backend.Interpret(parse(t, `try { throw 2; } catch (e) { result = e; }`)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result, _ := backend.Get("result"); result != 2.0 {
+			t.Errorf("%s: expected 2, got %v", name, result)
+		}
+	}
+}
+
+func TestBackends_StackTrace(t *testing.T) {
+	prog := `fun inner() {
+		return nil + 1;
+	}
+	var outer = fun() {
+		inner();
+	};
+	outer();`
+	want := []string{"[line 2] in inner()", "[line 5] in <fn>", "[line 7] in script"}
+	for name, kind := range backends {
+		err := newTestBackend(kind).Interpret(parse(t, prog))
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("%s: expected *RuntimeError, got %v", name, err)
+		}
+		if !reflect.DeepEqual(rerr.Stack, want) {
+			t.Errorf("%s: unexpected stack trace %q", name, rerr.Stack)
+		}
+		if !strings.HasSuffix(rerr.Error(), "\n"+strings.Join(want, "\n")) {
+			t.Errorf("%s: expected the stack trace in %q", name, rerr.Error())
+		}
+	}
+}
+
+func TestBackends_MaxCallDepth(t *testing.T) {
+	prog := `fun count(n) {
+		if (n == 0) return 0;
+		return 1 + count(n - 1);
+	}`
+	for name, kind := range backends {
+		for _, depth := range []int{10, 200, 5000} {
+			backend := newTestBackend(kind)
+			backend.SetMaxCallDepth(depth)
+			// The script itself takes one frame.
+			fits := fmt.Sprintf("\nvar result = count(%d);", depth-2)
+			if err := backend.Interpret(parse(t, prog+fits)); err != nil {
+				t.Fatalf("%s: depth %d: %v", name, depth, err)
+			}
+			err := backend.Interpret(parse(t, prog+fmt.Sprintf("\ncount(%d);", depth-1)))
+			rerr, ok := err.(*RuntimeError)
+			if !ok || rerr.Message != "Stack overflow." || len(rerr.Stack) != depth {
+				t.Fatalf("%s: expected a stack overflow %d frames deep, got %v", name, depth, err)
+			}
+		}
+	}
+}
+
+func TestBackends_LargeLiterals(t *testing.T) {
+	elements := strings.Repeat("1, ", 19999) + "1"
+	entries := strings.Repeat("0: 1, ", 19999) + "0: 1"
+	// The stack first grows while x is captured, so the closure must follow it.
+	prog := "fun captured() { var x = 1; fun get() { return x; } var l = [" + elements + "]; x = 2; return get(); }\n" +
+		"var seen = captured();\n" +
+		"var list = [" + elements + "];\nvar map = {" + entries + "};\n" +
+		"fun nested() { return len([" + elements + "]); }\nvar count = nested();"
+	for name, kind := range backends {
+		backend := newTestBackend(kind)
+		if err := backend.Interpret(parse(t, prog)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if list, _ := backend.Get("list"); len(list.(*LoxList).elements) != 20000 {
+			t.Errorf("%s: expected 20000 elements, got %v", name, len(list.(*LoxList).elements))
+		}
+		if count, _ := backend.Get("count"); count != 20000.0 {
+			t.Errorf("%s: expected 20000 elements in a function, got %v", name, count)
+		}
+		if seen, _ := backend.Get("seen"); seen != 2.0 {
+			t.Errorf("%s: expected the captured variable to be 2, got %v", name, seen)
+		}
+	}
+}
+
+func TestVM_Fib(t *testing.T) {
+	vm := NewVMWithOutput(ioutil.Discard, ioutil.Discard)
+	prog := `fun fib(n) {
+		if (n < 2) return n;
+		return fib(n - 1) + fib(n - 2);
+	}
+	var result = fib(15);`
+	if err := vm.Interpret(parse(t, prog)); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := vm.Get("result"); result != 610.0 {
+		t.Fatalf("expected 610, got %v", result)
+	}
+}
+
+func TestVM_Classes(t *testing.T) {
+	vm := NewVMWithOutput(ioutil.Discard, ioutil.Discard)
+	prog := `class Shape {
+		init(name) {
+			this.name = name;
+		}
+		describe() {
+			return this.name + " with " + this.sides() + " sides";
+		}
+	}
+	class Square < Shape {
+		init() {
+			super.init("square");
+		}
+		sides() {
+			return "four";
+		}
+		describe() {
+			return "a " + super.describe();
+		}
+	}
+	var result = Square().describe();`
+	if err := vm.Interpret(parse(t, prog)); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := vm.Get("result"); result != "a square with four sides" {
+		t.Fatalf("unexpected result %v", result)
+	}
+}
+
+func TestVM_StackOverflow(t *testing.T) {
+	err := NewVMWithOutput(ioutil.Discard, ioutil.Discard).Interpret(parse(t, "fun f() { f(); }\nf();"))
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Message != "Stack overflow." {
+		t.Fatalf("expected a stack overflow, got %v", err)
+	}
+}
+
+func TestVM_CatchStackOverflow(t *testing.T) {
+	vm := NewVMWithOutput(ioutil.Discard, ioutil.Discard)
+	prog := `fun f() { f(); }
+	var result;
+	var depth;
+	try { f(); } catch (e) { result = e.message; depth = len(e.stack); }`
+	if err := vm.Interpret(parse(t, prog)); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := vm.Get("result"); result != "Stack overflow." {
+		t.Fatalf("expected a caught stack overflow, got %v", result)
+	}
+	if depth, _ := vm.Get("depth"); depth != float64(DefaultMaxCallDepth) {
+		t.Fatalf("expected %d frames in the stack trace, got %v", DefaultMaxCallDepth, depth)
+	}
+}
+
+func BenchmarkVM_Fib(b *testing.B) {
+	ast := parse(b, `fun fib(n) {
+		if (n < 2) return n;
+		return fib(n - 1) + fib(n - 2);
+	}
+	fib(20);`)
+	for n := 0; n < b.N; n++ {
+		if err := NewVMWithOutput(ioutil.Discard, ioutil.Discard).Interpret(ast); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"lisp/lox"
+)
+
+// keywords are offered by completion alongside the names in scope.
+var keywords = []string{
+	"and", "break", "catch", "class", "continue", "else", "false", "finally", "for", "fun", "if",
+	"import", "nil", "or", "print", "return", "super", "this", "throw", "true", "try", "var", "while",
+}
+
+// Server answers requests about the Lox documents an editor has open. Each
+// document is analyzed again whenever it changes.
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*document
+}
+
+type document struct {
+	lines    []string
+	analysis *lox.Analysis
+}
+
+// response is a reply to a request. Result is left nil when Error is set.
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: make(map[string]*document),
+	}
+}
+
+// Run serves requests until the client sends exit or closes the input.
+func (s *Server) Run() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if msg != nil && err != nil {
+			s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, rerr := s.handle(msg)
+		if msg.ID != nil {
+			if err := s.reply(msg.ID, result, rerr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle dispatches one request or notification. Unknown notifications are
+// ignored, as the protocol requires.
+func (s *Server) handle(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1,
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "lox"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			changes := params.ContentChanges
+			s.update(params.TextDocument.URI, changes[len(changes)-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			delete(s.documents, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics",
+				publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+		}
+		return nil, nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.hover(params), nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.definition(params), nil
+	case "textDocument/references":
+		var params referenceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.references(params), nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.documentSymbols(params.TextDocument.URI), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.completion(params), nil
+	}
+	if msg.ID == nil {
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// update analyzes the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri string, text string) {
+	doc := &document{lines: strings.Split(text, "\n"), analysis: lox.Analyze(uri, text)}
+	s.documents[uri] = doc
+
+	diagnostics := []diagnostic{}
+	for _, d := range doc.analysis.Diagnostics {
+		severity := severityError
+		if d.Warning {
+			severity = severityWarning
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    doc.toRange(d.Span),
+			Severity: severity,
+			Code:     d.Check,
+			Source:   "lox",
+			Message:  d.Message,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+// lookup finds the document and the symbol at a position in it.
+func (s *Server) lookup(params textDocumentPositionParams) (*document, *lox.Symbol, lox.Span, bool) {
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, nil, lox.Span{}, false
+	}
+	line, column := doc.fromPosition(params.Position)
+	sym, span, ok := doc.analysis.Lookup(line, column)
+	return doc, sym, span, ok
+}
+
+func (s *Server) hover(params textDocumentPositionParams) interface{} {
+	doc, sym, span, ok := s.lookup(params)
+	if !ok {
+		return nil
+	}
+	return hover{
+		Contents: markupContent{Kind: "markdown", Value: "```lox\n" + sym.Detail + "\n```\n" + describe(sym)},
+		Range:    doc.toRange(span),
+	}
+}
+
+func (s *Server) definition(params textDocumentPositionParams) interface{} {
+	doc, sym, _, ok := s.lookup(params)
+	if !ok {
+		return nil
+	}
+	return location{URI: params.TextDocument.URI, Range: doc.toRange(sym.NameSpan)}
+}
+
+func (s *Server) references(params referenceParams) []location {
+	locations := []location{}
+	doc, sym, _, ok := s.lookup(params.textDocumentPositionParams)
+	if !ok {
+		return locations
+	}
+	uri := params.TextDocument.URI
+	if params.Context.IncludeDeclaration {
+		locations = append(locations, location{URI: uri, Range: doc.toRange(sym.NameSpan)})
+	}
+	for _, span := range doc.analysis.ReferencesTo(sym) {
+		locations = append(locations, location{URI: uri, Range: doc.toRange(span)})
+	}
+	return locations
+}
+
+// documentSymbols lists the functions and classes of a document, with the
+// methods of each class as its children.
+func (s *Server) documentSymbols(uri string) []documentSymbol {
+	symbols := []documentSymbol{}
+	doc, ok := s.documents[uri]
+	if !ok {
+		return symbols
+	}
+	for _, sym := range doc.analysis.Symbols {
+		switch sym.Kind {
+		case lox.FunctionSymbol:
+			symbols = append(symbols, doc.toSymbol(sym, symbolKindFunction))
+		case lox.ClassSymbol:
+			class := doc.toSymbol(sym, symbolKindClass)
+			for _, method := range sym.Children {
+				class.Children = append(class.Children, doc.toSymbol(method, symbolKindMethod))
+			}
+			symbols = append(symbols, class)
+		}
+	}
+	return symbols
+}
+
+func (s *Server) completion(params textDocumentPositionParams) []completionItem {
+	items := []completionItem{}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return items
+	}
+	line, column := doc.fromPosition(params.Position)
+	for _, sym := range doc.analysis.Visible(line, column) {
+		kind := completionKindVariable
+		switch sym.Kind {
+		case lox.FunctionSymbol:
+			kind = completionKindFunction
+		case lox.ClassSymbol:
+			kind = completionKindClass
+		case lox.MethodSymbol:
+			kind = completionKindMethod
+		}
+		items = append(items, completionItem{Label: sym.Name, Kind: kind, Detail: sym.Detail})
+	}
+	for _, keyword := range keywords {
+		items = append(items, completionItem{Label: keyword, Kind: completionKindKeyword})
+	}
+	return items
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	resp := &response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msg := json.RawMessage(raw)
+		resp.Result = &msg
+	}
+	return writeMessage(s.out, resp)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, &notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+// describe names the kind of declaration sym is, for hover.
+func describe(sym *lox.Symbol) string {
+	switch sym.Kind {
+	case lox.ParameterSymbol, lox.MethodSymbol:
+		return sym.Kind.String()
+	}
+	if sym.Global {
+		return "global " + sym.Kind.String()
+	}
+	return "local " + sym.Kind.String()
+}
+
+func (d *document) toSymbol(sym *lox.Symbol, kind int) documentSymbol {
+	return documentSymbol{
+		Name:           sym.Name,
+		Detail:         sym.Detail,
+		Kind:           kind,
+		Range:          d.toRange(sym.DeclSpan),
+		SelectionRange: d.toRange(sym.NameSpan),
+	}
+}
+
+func (d *document) toRange(span lox.Span) rangeLSP {
+	return rangeLSP{Start: d.toPosition(span.Start), End: d.toPosition(span.End)}
+}
+
+// toPosition converts a 1-based line and byte column to a protocol position.
+func (d *document) toPosition(p lox.Position) position {
+	line := p.Line - 1
+	if line < 0 {
+		return position{}
+	}
+	if line >= len(d.lines) {
+		return position{Line: line}
+	}
+	text := d.lines[line]
+	column := p.Column - 1
+	if column < 0 {
+		column = 0
+	}
+	if column > len(text) {
+		column = len(text)
+	}
+	return position{Line: line, Character: len(utf16.Encode([]rune(text[:column])))}
+}
+
+// fromPosition converts a protocol position to a 1-based line and byte
+// column.
+func (d *document) fromPosition(p position) (int, int) {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return p.Line + 1, 1
+	}
+	text := d.lines[p.Line]
+	units, offset := 0, 0
+	for offset < len(text) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return p.Line + 1, offset + 1
+}
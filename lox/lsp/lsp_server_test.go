@@ -0,0 +1,168 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+const uri = "file:///test.lox"
+
+const script = `var greeting = "héllo";
+fun greet(name) {
+  var unused = 1;
+  print greeting + name;
+}
+class Greeter {
+  hello() {}
+}
+greet("you");
+print 1 +;`
+
+// session runs a server over the given messages and returns what it wrote,
+// keyed by request ID, with notifications under their method name.
+func session(t *testing.T, requests ...string) map[string][]json.RawMessage {
+	var in bytes.Buffer
+	for _, req := range requests {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(req), req)
+	}
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := make(map[string][]json.RawMessage)
+	r := bufio.NewReader(&out)
+	for r.Buffered() > 0 || out.Len() > 0 {
+		msg, err := readRaw(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var head struct {
+			ID     *json.RawMessage `json:"id"`
+			Method string           `json:"method"`
+			Result json.RawMessage  `json:"result"`
+			Params json.RawMessage  `json:"params"`
+			Error  *responseError   `json:"error"`
+		}
+		if err := json.Unmarshal(msg, &head); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case head.Error != nil:
+			replies["error"] = append(replies["error"], msg)
+		case head.ID != nil:
+			replies[string(*head.ID)] = append(replies[string(*head.ID)], head.Result)
+		default:
+			replies[head.Method] = append(replies[head.Method], head.Params)
+		}
+	}
+	return replies
+}
+
+func readRaw(r *bufio.Reader) (json.RawMessage, error) {
+	var length int
+	if _, err := fmt.Fscanf(r, "Content-Length: %d\r\n\r\n", &length); err != nil {
+		return nil, err
+	}
+	body := make([]byte, length)
+	_, err := r.Read(body)
+	return body, err
+}
+
+func open(text string) string {
+	params, _ := json.Marshal(didOpenParams{TextDocument: textDocumentItem{URI: uri, Text: text}})
+	return `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":` + string(params) + `}`
+}
+
+func request(id int, method string, line int, character int) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":{"textDocument":{"uri":%q},`+
+		`"position":{"line":%d,"character":%d},"context":{"includeDeclaration":true}}}`,
+		id, method, uri, line, character)
+}
+
+func TestServer_Diagnostics(t *testing.T) {
+	replies := session(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`, open(script))
+
+	var published publishDiagnosticsParams
+	if err := json.Unmarshal(replies["textDocument/publishDiagnostics"][0], &published); err != nil {
+		t.Fatal(err)
+	}
+	if len(published.Diagnostics) != 2 {
+		t.Fatalf("expected two diagnostics, got %+v", published.Diagnostics)
+	}
+	syntax := published.Diagnostics[0]
+	if syntax.Severity != severityError || syntax.Range.Start != (position{Line: 9, Character: 9}) {
+		t.Errorf("unexpected syntax error %+v", syntax)
+	}
+	unused := published.Diagnostics[1]
+	if unused.Severity != severityWarning || unused.Code != "unused" || unused.Range.Start.Line != 2 {
+		t.Errorf("unexpected warning %+v", unused)
+	}
+}
+
+func TestServer_Navigation(t *testing.T) {
+	replies := session(t, open(script),
+		request(1, "textDocument/definition", 3, 10),
+		request(2, "textDocument/references", 0, 5),
+		request(3, "textDocument/hover", 8, 1),
+		request(4, "textDocument/definition", 3, 22),
+	)
+
+	var def location
+	json.Unmarshal(replies["1"][0], &def)
+	if def.Range.Start != (position{Line: 0, Character: 4}) {
+		t.Errorf("expected greeting to be defined at 0:4, got %+v", def.Range)
+	}
+
+	var refs []location
+	json.Unmarshal(replies["2"][0], &refs)
+	if len(refs) != 2 || refs[0].Range.Start.Line != 0 || refs[1].Range.Start.Line != 3 {
+		t.Errorf("expected the declaration and one use of greeting, got %+v", refs)
+	}
+
+	var h hover
+	json.Unmarshal(replies["3"][0], &h)
+	if h.Contents.Value != "```lox\nfun greet(name)\n```\nglobal function" {
+		t.Errorf("unexpected hover %q", h.Contents.Value)
+	}
+
+	var param location
+	json.Unmarshal(replies["4"][0], &param)
+	if param.Range.Start != (position{Line: 1, Character: 10}) {
+		t.Errorf("expected name to be defined at 1:10, got %+v", param.Range)
+	}
+}
+
+func TestServer_SymbolsAndCompletion(t *testing.T) {
+	replies := session(t, open(script),
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/documentSymbol","params":{"textDocument":{"uri":"`+uri+`"}}}`,
+		request(2, "textDocument/completion", 3, 2),
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/formatting","params":{}}`,
+	)
+
+	var symbols []documentSymbol
+	json.Unmarshal(replies["1"][0], &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "greet" || symbols[1].Name != "Greeter" ||
+		len(symbols[1].Children) != 1 || symbols[1].Children[0].Name != "hello" {
+		t.Errorf("unexpected document symbols %+v", symbols)
+	}
+
+	var items []completionItem
+	json.Unmarshal(replies["2"][0], &items)
+	var labels []string
+	for _, item := range items {
+		if item.Kind != completionKindKeyword {
+			labels = append(labels, item.Label)
+		}
+	}
+	if fmt.Sprint(labels) != "[unused name greeting greet Greeter]" {
+		t.Errorf("unexpected completions %v", labels)
+	}
+
+	if len(replies["error"]) != 1 {
+		t.Errorf("expected an unknown method to be rejected, got %v", replies)
+	}
+}